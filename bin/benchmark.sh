@@ -0,0 +1,97 @@
+#!/bin/bash
+
+# 生成一个大的合成代码目录, 分别统计基准版本和新版本解析和输出的耗时
+# 用法: benchmark.sh [包数量] [每个包的struct数量] [新版本路径] [基准版本]
+# 基准版本可以是可执行文件, 也可以是git版本, 默认是加入符号索引之前的版本, 这时在临时的GOPATH中编译
+# 基准版本是平方复杂度, 每次运行最多TIMEOUT秒
+
+PACKAGES=${1:-20}
+TYPES=${2:-10}
+C=${3:-go-package-plantuml}
+BASELINE=${4:-$(git -C $(dirname $0)/.. log --diff-filter=A --format=%H -- codeanalysis/symbols.go)^}
+TIMEOUT=${TIMEOUT:-600}
+WORKDIR=$(mktemp -d)
+CODEDIR=$WORKDIR/src
+OUTPUTDIR=$WORKDIR/out
+
+mkdir -p $CODEDIR $OUTPUTDIR
+echo "module example.com/bench" > $CODEDIR/go.mod
+
+if [ ! -x "$BASELINE" ]; then
+    REPO=$(cd $(dirname $0)/.. && pwd)
+    PROJECT=$WORKDIR/gopath/src/github.com/ping40/go-package-plantuml
+    mkdir -p $(dirname $PROJECT)
+    git -C $REPO worktree add -f --detach -q $PROJECT $BASELINE || exit 1
+    (cd $PROJECT && GOPATH=$WORKDIR/gopath GO111MODULE=off go build -o $WORKDIR/baseline .)
+    git -C $REPO worktree remove --force $PROJECT
+    [ -x $WORKDIR/baseline ] || exit 1
+    echo "基准版本: $BASELINE"
+    BASELINE=$WORKDIR/baseline
+fi
+
+p=0
+while [ $p -lt $PACKAGES ]
+do
+    mkdir -p $CODEDIR/pkg$p
+    {
+        echo "package pkg$p"
+        echo
+        if [ $p -gt 0 ]; then
+            echo "import \"example.com/bench/pkg$((p-1))\""
+            echo
+        fi
+        t=0
+        while [ $t -lt $TYPES ]
+        do
+            echo "type Service$t interface {"
+            echo "	Do$t(n int) (*Model$t, error)"
+            echo "}"
+            echo
+            echo "type Model$t struct {"
+            if [ $p -gt 0 ]; then
+                echo "	pkg$((p-1)).Model$t"
+                echo "	parent *pkg$((p-1)).Model$t"
+                echo "	services []pkg$((p-1)).Service$t"
+            fi
+            echo "	children map[string]*Model$(( (t+1) % TYPES ))"
+            echo "}"
+            echo
+            echo "func (m *Model$t) Do$t(n int) (*Model$t, error) { return m, nil }"
+            echo
+            t=$((t+1))
+        done
+    } > $CODEDIR/pkg$p/types.go
+    p=$((p+1))
+done
+
+echo "$PACKAGES个包, 每个包$TYPES个struct和$TYPES个interface, 目录$CODEDIR"
+
+# 运行一次, 输出耗时的秒数, 超时输出 >TIMEOUT
+elapsed() {
+    local start=$(date +%s.%N)
+    timeout $TIMEOUT "$@" --outputdir $OUTPUTDIR > /dev/null 2>&1
+    if [ $? -eq 124 ]; then
+        echo ">$TIMEOUT"
+        return
+    fi
+    awk -v start=$start -v end=$(date +%s.%N) 'BEGIN {printf "%.2f", end - start}'
+}
+
+printf "%-12s %12s %12s %10s\n" "模式" "基准版本(s)" "新版本(s)" "加速"
+
+for mode in "全部" "nodename"
+do
+    args="--codedir $CODEDIR"
+    if [ "$mode" = "nodename" ]; then
+        args="$args --nodename Model0 --nodedepth 3"
+    fi
+
+    old=$(elapsed $BASELINE $args)
+    new=$(elapsed $C $args)
+
+    speedup=$(echo "$old $new" | awk '$1 !~ />/ && $2 > 0 {printf "%.1fx", $1 / $2; next} {print "-"}')
+    printf "%-12s %12s %12s %10s\n" "$mode" "$old" "$new" "$speedup"
+done
+
+wc -l $OUTPUTDIR/*.puml
+rm -rf $WORKDIR
@@ -0,0 +1,157 @@
+package codeanalysis
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// 别名指向别名时最多查找的层数
+const maxAliasDepth = 16
+
+// 别名的目标类型可以定义在其他文件, 所有类型合并后再解析, 合并时再设置到别名上
+type pendingAliasTarget struct {
+	meta           *typeAliasMeta
+	target         *typeKey
+	targetTypeName string
+}
+
+// 解析本文件中 type A = B 形式的别名指向的类型.
+// 这时所有文件的struct/interface和包名都已经合并, 可以确定import的包名
+func (this *fileContext) visitAliasInFile() {
+
+	this.visitImports()
+
+	aliasMetas := map[string]*typeAliasMeta{}
+	for _, meta := range this.newTypeAliasMetas {
+		aliasMetas[meta.Name] = meta
+	}
+
+	for _, decl := range this.file.file.Decls {
+
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+
+			typeSpec, ok := spec.(*ast.TypeSpec)
+			if !ok || !typeSpec.Assign.IsValid() {
+				continue
+			}
+
+			meta, ok := aliasMetas[typeSpec.Name.Name]
+			if !ok {
+				continue
+			}
+
+			this.typeParams = typeParamNames(typeSpec.TypeParams)
+			this.visitAliasTarget(meta, typeSpec.Type)
+			this.typeParams = nil
+		}
+	}
+}
+
+func (this *fileContext) visitAliasTarget(meta *typeAliasMeta, t ast.Expr) {
+
+	pending := &pendingAliasTarget{meta: meta}
+
+	ident, ok := t.(*ast.Ident)
+	if ok && !this.isGoBaseType(ident.Name) && !sliceContains(this.typeParams, ident.Name) {
+		pending.target = &typeKey{PackagePath: this.currentPackagePath, Name: ident.Name}
+	}
+
+	selectorExpr, ok := t.(*ast.SelectorExpr)
+	if ok {
+		packagePath := this.findPackagePathByAlias(this.selectorExprToString(selectorExpr.X), selectorExpr.Sel.Name, selectorExpr.Pos())
+		if packagePath != "" {
+			pending.target = &typeKey{PackagePath: packagePath, Name: selectorExpr.Sel.Name}
+		}
+	}
+
+	if pending.target == nil {
+		// 基本类型, 复合类型和泛型类型的实例, 方法签名中使用完整的目标类型
+		pending.targetTypeName = this.typeToString(t, true)
+
+		// 泛型类型的实例, 例如 type UserList = List[User], 依赖关系指向List
+		if genericType, typeArguments := splitTypeArguments(t); typeArguments != nil {
+			if genericMeta, _ := this.analysisTypeForDependencyRelation(genericType); genericMeta != nil {
+				key := genericMeta.key()
+				pending.target = &key
+			}
+		}
+	}
+
+	this.newAliasTargets = append(this.newAliasTargets, pending)
+}
+
+// 合并解析出的别名目标
+func (this *analysisTool) mergeAliases(ctx *fileContext) {
+
+	for _, a := range ctx.newAliasTargets {
+		a.meta.target = a.target
+		a.meta.targetTypeName = a.targetTypeName
+	}
+
+	this.mergeDiagnostics(ctx)
+}
+
+// 带包路径的类型名, 别名换成它最终指向的类型, 这样别名和目标类型的方法签名一致
+func (this *analysisTool) uniqueTypeName(packagePath string, name string) string {
+
+	key := typeKey{PackagePath: packagePath, Name: name}
+
+	for i := 0; i < maxAliasDepth; i++ {
+		if _, ok := this.symbols.structs[key]; ok {
+			break
+		}
+
+		alias, ok := this.symbols.typeAliass[key]
+		if !ok {
+			break
+		}
+		if alias.targetTypeName != "" {
+			return alias.targetTypeName
+		}
+		if alias.target == nil {
+			break
+		}
+		key = *alias.target
+	}
+
+	return key.PackagePath + "." + key.Name
+}
+
+// ShowAlias时, 指向struct/interface的别名显示为单独的节点, 用虚线指向目标类型
+func (this *analysisTool) addAliasNodes() {
+
+	for _, alias := range this.typeAliasMetas {
+
+		target := this.findStruct(alias.PackagePath, alias.Name)
+		if target == nil {
+			continue
+		}
+
+		aliasMeta1 := &structMeta{
+			baseInfo:    alias.baseInfo,
+			Name:        alias.Name,
+			MethodSigns: []string{},
+			category:    AliasCategory,
+			isTest:      target.isTest,
+		}
+		aliasMeta1.UML = this.aliasToUML(aliasMeta1)
+
+		this.addDisplayNode(aliasMeta1)
+		this.addDependencyRelation(&DependencyRelation{
+			source: aliasMeta1,
+			target: target,
+			uml:    aliasMeta1.UniqueNameUML() + " ..> " + target.UniqueNameUML() + " : alias",
+			kind:   AliasRelation,
+		})
+	}
+}
+
+func (this *analysisTool) aliasToUML(me *structMeta) string {
+	classUML := "class " + me.Name + " <<alias>>" + me.ColorfulUML() + " {\n}"
+	return fmt.Sprintf("namespace %s {\n %s \n}", this.packagePathToUML(me.PackagePath), classUML)
+}
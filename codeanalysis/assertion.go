@@ -0,0 +1,133 @@
+package codeanalysis
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// 用 var _ fab.Peer = (*peer)(nil) 声明的实现关系
+type implAssertion struct {
+	iface *structMeta
+	impl  *structMeta
+	// 断言的是*impl还是impl
+	pointer bool
+	file    string
+	line    int
+}
+
+// 实现关系的断言, 例如 var _ I = (*T)(nil), var _ I = &T{}, var _ I = T{}
+func (this *fileContext) visitAssertion(valueSpec *ast.ValueSpec, i int) {
+
+	if valueSpec.Type == nil || len(valueSpec.Values) != len(valueSpec.Names) {
+		return
+	}
+
+	iface, _ := this.analysisTypeForDependencyRelation(valueSpec.Type)
+	if iface == nil || iface.category != InterfaceCategory {
+		return
+	}
+
+	t, pointer := assertedType(valueSpec.Values[i])
+	if t == nil {
+		return
+	}
+
+	impl := this.bodyTypeMeta(t)
+	if impl == nil || !impl.isConcrete() {
+		return
+	}
+
+	this.newAssertions = append(this.newAssertions, &implAssertion{
+		iface:   iface,
+		impl:    impl,
+		pointer: pointer,
+		file:    this.currentFile,
+		line:    this.fset.Position(valueSpec.Type.Pos()).Line,
+	})
+}
+
+// 断言中实现接口的类型, pointer表示断言的是指针类型
+func assertedType(value ast.Expr) (t ast.Expr, pointer bool) {
+
+	unaryExpr, ok := value.(*ast.UnaryExpr)
+	if ok && unaryExpr.Op == token.AND {
+		t, _ = assertedType(unaryExpr.X)
+		return t, true
+	}
+
+	compositeLit, ok := value.(*ast.CompositeLit)
+	if ok {
+		return compositeLit.Type, false
+	}
+
+	callExpr, ok := value.(*ast.CallExpr)
+	if !ok {
+		return nil, false
+	}
+
+	ident, ok := callExpr.Fun.(*ast.Ident)
+	if ok && ident.Name == "new" && len(callExpr.Args) == 1 {
+		return callExpr.Args[0], true
+	}
+
+	// 类型转换, 例如 (*T)(nil)
+	fun := callExpr.Fun
+	for {
+		parenExpr, ok := fun.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		fun = parenExpr.X
+	}
+
+	starExpr, ok := fun.(*ast.StarExpr)
+	if ok {
+		return starExpr.X, true
+	}
+
+	return fun, false
+}
+
+func (this *analysisTool) addAssertion(a *implAssertion) {
+	this.assertions = append(this.assertions, a)
+	pair := [2]*structMeta{a.iface, a.impl}
+	this.symbols.assertions[pair] = append(this.symbols.assertions[pair], a)
+}
+
+func (this *analysisTool) asserted(definedInterface, impl *structMeta) bool {
+	return len(this.symbols.assertions[[2]*structMeta{definedInterface, impl}]) > 0
+}
+
+// 检查断言的实现关系是否能确认, CheckAssertions时还检查推断出的实现关系是否都有断言
+func (this *analysisTool) checkAssertions() {
+
+	for _, a := range this.assertions {
+		implements, pointerOnly := this.implementsInterface(a.iface, a.impl)
+		if !implements || (pointerOnly && !a.pointer) {
+			name := a.impl.Name
+			if a.pointer {
+				name = "*" + name
+			}
+			this.addToolDiagnostic(UnconfirmedAssertionDiagnostic, WarningSeverity, a.file, a.line, "无法确认%s实现了%s.%s", name, a.iface.PackagePath, a.iface.Name)
+		}
+	}
+
+	if !this.config.CheckAssertions {
+		return
+	}
+
+	for _, iface := range this.structMetas {
+		if iface.category != InterfaceCategory {
+			continue
+		}
+
+		for _, impl := range this.structMetas {
+			if !impl.isConcrete() || this.asserted(iface, impl) || this.embeds(impl, iface) {
+				continue
+			}
+			if implements, _ := this.implementsInterface(iface, impl); implements {
+				this.addToolDiagnostic(MissingAssertionDiagnostic, WarningSeverity, impl.FilePath, 0, "%s.%s实现了%s.%s, 但是没有断言", impl.PackagePath, impl.Name, iface.PackagePath, iface.Name)
+			}
+		}
+	}
+}
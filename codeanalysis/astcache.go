@@ -0,0 +1,132 @@
+package codeanalysis
+
+import (
+	"go/ast"
+	"go/parser"
+	"io/ioutil"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// 解析过的go文件, 所有文件共用analysisTool.fset
+type parsedFile struct {
+	// go文件路径
+	path string
+	// 所在包路径
+	packagePath string
+	// 文件是否读取失败, 文件内容只在解析时使用, 不保留
+	readFailed bool
+	// 构建约束是否有语法错误, 这时无法确定是否参与编译, 文件不解析
+	badConstraint bool
+	file          *ast.File
+	// 是否是测试文件
+	isTest bool
+	// 当前构建环境下是否参与编译, 以及文件的构建约束
+	match           bool
+	buildConstraint string
+}
+
+// 遍历代码目录, 每个go文件只读取和解析一次, 构建约束也从读取的内容中判断,
+// 不参与编译的文件不解析, 解析失败的文件记录诊断信息后跳过.
+// 文件并发解析, 结果仍按遍历顺序保存
+func (this *analysisTool) parseFiles() {
+
+	files := []*parsedFile{}
+	reused := []bool{}
+	this.walkGoFiles(func(path string) {
+		if file, ok := this.reusableFiles[path]; ok {
+			files = append(files, file)
+			reused = append(reused, true)
+			return
+		}
+		files = append(files, &parsedFile{
+			path:        path,
+			packagePath: this.filepathToPackagePath(path),
+			isTest:      this.checkIsTest(path),
+		})
+		reused = append(reused, false)
+	})
+
+	errs := make([]error, len(files))
+	this.runJobs(len(files), func(i int) {
+
+		if reused[i] {
+			return
+		}
+
+		file := files[i]
+
+		src, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			file.readFailed = true
+			errs[i] = err
+			return
+		}
+
+		file.match, file.buildConstraint, errs[i] = this.matchBuildContext(file.path, src)
+		if errs[i] != nil {
+			file.badConstraint = true
+			return
+		}
+		if !file.match {
+			return
+		}
+		file.file, errs[i] = this.parseFile(file.path, src)
+	})
+
+	for i, file := range files {
+
+		if errs[i] == nil {
+			this.files[file.path] = file
+			if !file.match {
+				log.Debugf("文件%s不满足构建约束, 跳过\n", file.path)
+				continue
+			}
+			this.fileConstraints[file.path] = file.buildConstraint
+			this.parsedFiles = append(this.parsedFiles, file)
+			continue
+		}
+
+		ctx := this.newFileContext(file)
+		if file.readFailed {
+			ctx.addDiagnostic(ParseErrorDiagnostic, ErrorSeverity, 0, "读取文件失败, %s", errs[i])
+		} else if file.badConstraint {
+			ctx.addDiagnostic(ParseErrorDiagnostic, ErrorSeverity, 0, "构建约束无法解析, %s", errs[i])
+		} else {
+			ctx.addParseDiagnostics(errs[i])
+		}
+		this.mergeDiagnostics(ctx)
+	}
+}
+
+// 解析文件, 使用缓存时, 内容没有变化的文件使用缓存的内容, 不再完整解析.
+// 类型检查需要完整的语法树, 这时只更新缓存
+func (this *analysisTool) parseFile(filepath string, src []byte) (*ast.File, error) {
+
+	key := ""
+
+	if this.facts != nil {
+		key = this.facts.key(src)
+
+		if !this.config.TypeCheck {
+			if facts, ok := this.facts.load(key); ok {
+				file, err := this.factsToFile(filepath, facts)
+				if err == nil {
+					log.Debugf("使用缓存%s, 文件%s\n", key, filepath)
+					return file, nil
+				}
+				log.Warnf("缓存%s无法使用, %s\n", key, err)
+			}
+		}
+	}
+
+	log.Info("解析 " + filepath)
+
+	file, err := parser.ParseFile(this.fset, filepath, src, parser.ParseComments)
+	if err != nil || this.facts == nil {
+		return file, err
+	}
+
+	this.facts.save(key, this.extractFacts(src, file))
+	return file, nil
+}
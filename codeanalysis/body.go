@@ -0,0 +1,330 @@
+package codeanalysis
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// 函数体中和类型有关的表达式, 只按语法提取, 解析和缓存共用
+type bodyRef struct {
+	kind RelationKind
+	// InstantiateRelation时是类型表达式, CallRelation时是被调用的方法, 例如 r.peer.Send
+	expr ast.Expr
+	pos  token.Pos
+}
+
+// 函数体中创建的类型(&peer{...}, new(peer), peer(x)), 以及调用的方法
+func bodyRefs(body *ast.BlockStmt) []bodyRef {
+
+	refs := []bodyRef{}
+
+	if body == nil {
+		return refs
+	}
+
+	ast.Inspect(body, func(node ast.Node) bool {
+
+		compositeLit, ok := node.(*ast.CompositeLit)
+		if ok && compositeLit.Type != nil {
+			refs = append(refs, bodyRef{kind: InstantiateRelation, expr: compositeLit.Type, pos: compositeLit.Pos()})
+		}
+
+		callExpr, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		ident, ok := callExpr.Fun.(*ast.Ident)
+		if ok && ident.Name == "new" && len(callExpr.Args) == 1 {
+			refs = append(refs, bodyRef{kind: InstantiateRelation, expr: callExpr.Args[0], pos: callExpr.Pos()})
+		} else if len(callExpr.Args) == 1 && isTypeLikeExpr(callExpr.Fun) {
+			// 可能是类型转换, 解析时再确定是不是类型
+			refs = append(refs, bodyRef{kind: InstantiateRelation, expr: callExpr.Fun, pos: callExpr.Pos()})
+		}
+
+		selectorExpr, ok := callExpr.Fun.(*ast.SelectorExpr)
+		if ok {
+			refs = append(refs, bodyRef{kind: CallRelation, expr: selectorExpr, pos: callExpr.Pos()})
+		}
+
+		return true
+	})
+
+	return refs
+}
+
+// 语法上可能是类型的表达式, 例如 T, pkg.T, (*T), List[T]
+func isTypeLikeExpr(t ast.Expr) bool {
+
+	switch expr := t.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		_, ok := expr.X.(*ast.Ident)
+		return ok
+	case *ast.ParenExpr:
+		return true
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return true
+	}
+
+	return false
+}
+
+// 调用的是接收者字段的方法, 例如 r.peer.Send(), 返回字段名
+func receiverFieldOfCall(selectorExpr *ast.SelectorExpr, recvName string) (string, bool) {
+
+	if recvName == "" || recvName == "_" {
+		return "", false
+	}
+
+	fieldExpr, ok := selectorExpr.X.(*ast.SelectorExpr)
+	if !ok {
+		return "", false
+	}
+
+	ident, ok := fieldExpr.X.(*ast.Ident)
+	if !ok || ident.Name != recvName {
+		return "", false
+	}
+
+	return fieldExpr.Sel.Name, true
+}
+
+func receiverName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 || len(funcDecl.Recv.List[0].Names) == 0 {
+		return ""
+	}
+	return funcDecl.Recv.List[0].Names[0].Name
+}
+
+// 接收者字段的方法调用, 字段的类型可能在其他文件中才确定, 所有文件合并后再解析
+type pendingFieldCall struct {
+	source   *structMeta
+	field    string
+	position string
+}
+
+// 函数体中创建和调用的类型, 作为meta的instantiates/calls依赖, 记录出现的位置
+func (this *fileContext) visitFuncBody(meta *structMeta, recvName string, body *ast.BlockStmt) {
+
+	for _, ref := range bodyRefs(body) {
+
+		position := this.bodyPosition(ref.pos)
+
+		if ref.kind == InstantiateRelation {
+			this.addBodyRelation(meta, this.bodyTypeMeta(ref.expr), InstantiateRelation, position)
+			continue
+		}
+
+		selectorExpr := ref.expr.(*ast.SelectorExpr)
+
+		if target, ok := this.calledTypeByTypes(selectorExpr); ok {
+			this.addBodyRelation(meta, target, CallRelation, position)
+			continue
+		}
+
+		if field, ok := receiverFieldOfCall(selectorExpr, recvName); ok {
+			this.newFieldCalls = append(this.newFieldCalls, &pendingFieldCall{
+				source:   meta,
+				field:    field,
+				position: position,
+			})
+		}
+	}
+}
+
+// 位置使用相对代码目录的路径, 输出和代码目录所在的位置无关
+func (this *fileContext) bodyPosition(pos token.Pos) string {
+	filename := strings.TrimPrefix(this.currentFile, strings.TrimSuffix(this.config.CodeDir, "/")+"/")
+	return fmt.Sprintf("%s:%d", filename, this.fset.Position(pos).Line)
+}
+
+// 函数体中的类型表达式对应的struct/interface, 不是类型时返回nil, 不记录诊断信息
+func (this *fileContext) bodyTypeMeta(t ast.Expr) *structMeta {
+
+	for {
+		parenExpr, ok := t.(*ast.ParenExpr)
+		if ok {
+			t = parenExpr.X
+			continue
+		}
+		starExpr, ok := t.(*ast.StarExpr)
+		if ok {
+			t = starExpr.X
+			continue
+		}
+		break
+	}
+	t, _ = splitTypeArguments(t)
+
+	if meta, ok := this.findStructByTypes(t); ok {
+		return meta
+	}
+
+	ident, ok := t.(*ast.Ident)
+	if ok {
+		if this.isGoBaseType(ident.Name) || sliceContains(this.typeParams, ident.Name) {
+			return nil
+		}
+		return this.findStruct(this.currentPackagePath, ident.Name)
+	}
+
+	selectorExpr, ok := t.(*ast.SelectorExpr)
+	if ok {
+		ident, ok := selectorExpr.X.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		for _, import1 := range this.currentFileImports {
+			if import1.Alias == ident.Name {
+				if meta := this.findStruct(import1.Path, selectorExpr.Sel.Name); meta != nil {
+					return meta
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// 通过类型检查的结果确定方法调用的接收者类型, ok为false表示没有类型信息
+func (this *fileContext) calledTypeByTypes(selectorExpr *ast.SelectorExpr) (*structMeta, bool) {
+
+	if this.typesInfo == nil {
+		return nil, false
+	}
+
+	selection, ok := this.typesInfo.Selections[selectorExpr]
+	if !ok || selection.Kind() != types.MethodVal {
+		return nil, false
+	}
+
+	recv := selection.Recv()
+	pointer, ok := recv.(*types.Pointer)
+	if ok {
+		recv = pointer.Elem()
+	}
+
+	named, ok := types.Unalias(recv).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil, true
+	}
+
+	return this.findStruct(named.Obj().Pkg().Path(), named.Obj().Name()), true
+}
+
+// 同一对类型的同种依赖只画一次, 记录所有出现的位置
+func (this *fileContext) addBodyRelation(source *structMeta, target *structMeta, kind RelationKind, position string) {
+
+	if target == nil || target == source {
+		return
+	}
+
+	for _, d := range this.newRelations {
+		if d.source == source && d.target == target && d.kind == kind {
+			d.positions = append(d.positions, position)
+			return
+		}
+	}
+
+	this.newRelations = append(this.newRelations, newBodyRelation(source, target, kind, position))
+}
+
+func newBodyRelation(source *structMeta, target *structMeta, kind RelationKind, position string) *DependencyRelation {
+
+	label := "instantiates"
+	if kind == CallRelation {
+		label = "calls"
+	}
+
+	return &DependencyRelation{
+		source:    source,
+		target:    target,
+		uml:       source.UniqueNameUML() + " ..> " + target.UniqueNameUML() + " : " + label,
+		kind:      kind,
+		positions: []string{position},
+	}
+}
+
+// 所有文件合并后, 按字段的类型确定接收者字段的方法调用依赖
+func (this *analysisTool) resolveFieldCalls() {
+
+	for _, call := range this.fieldCalls {
+
+		target := this.fieldType(call.source, call.field)
+		if target == nil || target == call.source {
+			continue
+		}
+
+		if d := this.findRelation(call.source, target, CallRelation); d != nil {
+			d.positions = append(d.positions, call.position)
+			continue
+		}
+
+		this.addDependencyRelation(newBodyRelation(call.source, target, CallRelation, call.position))
+	}
+
+	this.fieldCalls = nil
+}
+
+// 字段的类型, 包括嵌入的字段
+func (this *analysisTool) fieldType(meta *structMeta, field string) *structMeta {
+	for _, kind := range []RelationKind{FieldRelation, InheritanceRelation} {
+		for _, d := range this.outgoingRelations(meta, kind) {
+			if sliceContains(d.fields, field) {
+				return d.target
+			}
+		}
+	}
+	return nil
+}
+
+// 函数体中的依赖在UML中加上出现的位置, 作为注释
+func (this *analysisTool) renderBodyRelations() {
+	for _, d := range this.dependencyRelations {
+		if !d.kind.inBody() {
+			continue
+		}
+		positions := []string{}
+		for _, position := range d.positions {
+			if !sliceContains(positions, position) {
+				positions = append(positions, position)
+			}
+		}
+		d.uml += "\n' " + strings.Join(positions, "\n' ")
+	}
+}
+
+// 嵌入字段的字段名, 也就是去掉包名, 指针和类型实参后的类型名
+func embeddedFieldName(t ast.Expr) string {
+
+	starExpr, ok := t.(*ast.StarExpr)
+	if ok {
+		t = starExpr.X
+	}
+	t, _ = splitTypeArguments(t)
+
+	selectorExpr, ok := t.(*ast.SelectorExpr)
+	if ok {
+		return selectorExpr.Sel.Name
+	}
+
+	ident, ok := t.(*ast.Ident)
+	if ok {
+		return ident.Name
+	}
+
+	return ""
+}
+
+func identNames(idents []*ast.Ident) []string {
+	names := []string{}
+	for _, ident := range idents {
+		names = append(names, ident.Name)
+	}
+	return names
+}
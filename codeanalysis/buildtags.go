@@ -0,0 +1,205 @@
+package codeanalysis
+
+import (
+	"bytes"
+	"go/build"
+	"go/build/constraint"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// 初始化构建环境, 没有指定时和go build一样使用本机的GOOS/GOARCH.
+// 交叉编译时和go build一样默认关闭cgo, 需要时可以用 --tags cgo 打开
+func (this *analysisTool) initBuildContext() {
+	if this.config.GOOS == "" {
+		this.config.GOOS = build.Default.GOOS
+	}
+	if this.config.GOARCH == "" {
+		this.config.GOARCH = build.Default.GOARCH
+	}
+
+	this.buildContext = build.Default
+	this.buildContext.GOOS = this.config.GOOS
+	this.buildContext.GOARCH = this.config.GOARCH
+	this.buildContext.BuildTags = this.config.BuildTags
+	this.buildContext.CgoEnabled = build.Default.CgoEnabled &&
+		this.config.GOOS == build.Default.GOOS && this.config.GOARCH == build.Default.GOARCH
+
+	log.Infof("GOOS=%s, GOARCH=%s, cgo=%v, tags=%v\n", this.config.GOOS, this.config.GOARCH, this.buildContext.CgoEnabled, this.config.BuildTags)
+}
+
+// 判断go文件在当前构建环境下是否参与编译, 同时返回文件的构建约束, 没有约束时为空.
+// src是已经读取的文件内容, 不再重新读取文件. 构建约束有语法错误时返回错误, 由调用者记录诊断信息
+func (this *analysisTool) matchBuildContext(filepath string, src []byte) (bool, string, error) {
+
+	ctxt := this.buildContext
+	ctxt.OpenFile = func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(src)), nil
+	}
+
+	match, err := ctxt.MatchFile(path.Dir(filepath), path.Base(filepath))
+	if err != nil {
+		return false, "", err
+	}
+	if !match {
+		return false, "", nil
+	}
+
+	if !this.config.AnnotateTags {
+		return true, "", nil
+	}
+
+	constraints := []string{}
+
+	expr := parseBuildConstraint(filepath, src)
+	for _, tag := range fileNameTags(path.Base(filepath)) {
+		// 例如plat_linux.go中的 //go:build linux, 文件名的约束已经包括在表达式中
+		if expr == nil || !impliesTag(expr, tag) {
+			constraints = append(constraints, tag)
+		}
+	}
+	if expr != nil {
+		constraints = append(constraints, expr.String())
+	}
+
+	return true, strings.Join(constraints, " && "), nil
+}
+
+// 文件名中的GOOS和GOARCH, 例如foo_linux_amd64.go.
+// 哪些是GOOS/GOARCH由go/build判断, 在不存在的平台none上不参与编译的就是平台标签
+func fileNameTags(name string) []string {
+
+	name = strings.TrimSuffix(name, ".go")
+	name = strings.TrimSuffix(name, "_test")
+
+	parts := strings.Split(name, "_")
+	n := len(parts)
+	if n < 2 {
+		return nil
+	}
+
+	last := parts[n-1]
+	if n >= 3 && !matchFileName("none", last, "x_"+parts[n-2]+"_"+last+".go") {
+		return []string{parts[n-2], last}
+	}
+
+	if !matchFileName("none", "none", "x_"+last+".go") {
+		return []string{last}
+	}
+
+	return nil
+}
+
+func matchFileName(goos string, goarch string, name string) bool {
+	ctxt := build.Context{
+		GOOS:     goos,
+		GOARCH:   goarch,
+		Compiler: "gc",
+		OpenFile: func(string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("package p\n")), nil
+		},
+	}
+	match, err := ctxt.MatchFile("", name)
+	return err == nil && match
+}
+
+// 表达式成立时tag是否一定成立, 也就是tag不成立时表达式不可能成立
+func impliesTag(expr constraint.Expr, tag string) bool {
+
+	tags := []string{}
+	expr.Eval(func(t string) bool {
+		if t != tag && !sliceContains(tags, t) {
+			tags = append(tags, t)
+		}
+		return false
+	})
+
+	// 标签太多时不再穷举, 认为不包括
+	if len(tags) > 16 {
+		return false
+	}
+
+	for bits := 0; bits < 1<<uint(len(tags)); bits++ {
+		satisfied := expr.Eval(func(t string) bool {
+			for i, other := range tags {
+				if other == t {
+					return bits&(1<<uint(i)) != 0
+				}
+			}
+			return false
+		})
+		if satisfied {
+			return false
+		}
+	}
+
+	return true
+}
+
+// package语句之前的构建约束, //go:build优先, 没有时合并所有 // +build.
+// 和go build一样跳过空行和注释, 包括 /* ... */ 形式的许可证头
+func parseBuildConstraint(filepath string, src []byte) constraint.Expr {
+
+	var goBuild constraint.Expr
+	var plusBuild constraint.Expr
+
+	header := string(src)
+	for {
+		header = strings.TrimLeft(header, " \t\r\n")
+
+		if strings.HasPrefix(header, "/*") {
+			end := strings.Index(header, "*/")
+			if end < 0 {
+				break
+			}
+			header = header[end+2:]
+			continue
+		}
+
+		if !strings.HasPrefix(header, "//") {
+			break
+		}
+
+		line := header
+		end := strings.IndexByte(header, '\n')
+		if end >= 0 {
+			line, header = header[:end], header[end+1:]
+		} else {
+			header = ""
+		}
+		line = strings.TrimSpace(line)
+
+		if !constraint.IsGoBuild(line) && !constraint.IsPlusBuild(line) {
+			continue
+		}
+
+		expr, err := constraint.Parse(line)
+		if err != nil {
+			log.Warnf("文件%s的构建约束%s无法解析, %s", filepath, line, err)
+			continue
+		}
+
+		if constraint.IsGoBuild(line) {
+			goBuild = expr
+		} else if plusBuild == nil {
+			plusBuild = expr
+		} else {
+			plusBuild = &constraint.AndExpr{X: plusBuild, Y: expr}
+		}
+	}
+
+	if goBuild != nil {
+		return goBuild
+	}
+	return plusBuild
+}
+
+func (this *analysisTool) annotateBuildConstraint(meta *structMeta) {
+	if this.config.AnnotateTags {
+		meta.buildConstraint = this.fileConstraints[meta.FilePath]
+	}
+}
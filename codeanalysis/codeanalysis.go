@@ -4,14 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -22,6 +25,10 @@ const (
 	UnkownCategory    Category = iota // value --> 0
 	InterfaceCategory                 // value --> 1
 	StructCategory                    // value --> 2
+	DefinedCategory                   // value --> 3, 基于func/slice/map/chan/基本类型等定义的类型
+	AliasCategory                     // value --> 4, type A = B 形式的别名, 只在ShowAlias时作为节点显示
+	VarCategory                       // value --> 5, 包级别的变量, 显示为对象
+	ExternalCategory                  // value --> 6, 代码目录以外的类型, 只在ShowExternal时显示
 )
 
 type Config struct {
@@ -31,21 +38,69 @@ type Config struct {
 	IgnoreDirs      []string
 	TestPartialDirs []string
 	IgnoreNodes     []string
+	// go.work所在目录, 不为空时扫描工作区中的所有模块
+	WorkspaceDir string
+	// 是否用go/types做类型检查, 类型无法确定时仍然走AST方式
+	TypeCheck bool
+	// 并发解析的goroutine数量, 小于等于0时使用CPU个数
+	Jobs int
+	// 缓存目录, 不为空时只重新解析内容变化了的文件
+	CacheDir string
+	// 构建环境, 为空时使用本机的GOOS/GOARCH
+	GOOS      string
+	GOARCH    string
+	BuildTags []string
+	// 是否在UML中标出只在特定构建约束下存在的类型
+	AnnotateTags bool
+	// 是否把 type A = B 形式的别名显示为单独的节点, 指向它的目标类型
+	ShowAlias bool
+	// 是否显示方法的参数和返回值类型形成的依赖
+	ShowUses bool
+	// 按nodename过滤时, 是否也沿着函数体中的instantiates/calls依赖查找
+	FollowBody bool
+	// 是否报告没有 var _ I = (*T)(nil) 断言的实现关系
+	CheckAssertions bool
+	// 是否把代码目录以外的类型显示为没有内容的节点, 例如 sync.Mutex
+	ShowExternal bool
 }
 
 type AnalysisResult interface {
-	OutputToFile(logdir string, nodename string, nodedepth uint16, showtest bool)
+	OutputToFile(logdir string, nodename string, nodedepth uint16, showtest bool) error
+	Diagnostics() []*Diagnostic
+	WriteDiagnostics(filename string, format string) error
 }
 
 func AnalysisCode(config Config) AnalysisResult {
-	tool := &analysisTool{
+	var facts *factCache
+	if config.CacheDir != "" {
+		facts = newFactCache(config.CacheDir)
+	}
+	return analysisCode(config, facts)
+}
+
+func analysisCode(config Config, facts *factCache) *analysisTool {
+	tool := newAnalysisTool(facts)
+	tool.analysis(config)
+	return tool
+}
+
+func newAnalysisTool(facts *factCache) *analysisTool {
+	return &analysisTool{
 		structMetas:                 []*structMeta{},
 		typeAliasMetas:              []*typeAliasMeta{},
 		packagePathPackageNameCache: map[string]string{},
 		dependencyRelations:         []*DependencyRelation{},
+		moduleCache:                 map[string]*moduleMeta{},
+		symbols:                     newSymbolTable(),
+		methodSetCache:              map[*structMeta]*methodSet{},
+		scannedPackages:             map[string]bool{},
+		externals:                   map[typeKey]*structMeta{},
+		fset:                        token.NewFileSet(),
+		fileConstraints:             map[string]string{},
+		fileStamps:                  map[string]fileStamp{},
+		facts:                       facts,
+		files:                       map[string]*parsedFile{},
 	}
-	tool.analysis(config)
-	return tool
 }
 
 func HasPrefixInSomeElement(value string, src []string, vendorDir string) bool {
@@ -127,6 +182,16 @@ func PathExists(path string) bool {
 	return false
 }
 
+// 转换成绝对路径, 失败时返回原来的路径
+func absDir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		log.Errorf("无法确定目录%s的绝对路径, %s\n", dir, err)
+		return dir
+	}
+	return abs
+}
+
 func packagePathToUML(packagePath string) string {
 	packagePath = strings.Replace(packagePath, "/", "\\\\", -1)
 	packagePath = strings.Replace(packagePath, "-", "_", -1)
@@ -145,6 +210,8 @@ type structMeta struct {
 	Name string
 	// 直接方法签名列表,不包括继承的,
 	MethodSigns []string
+	// 接收者是指针的方法签名
+	pointerMethodSigns map[string]bool
 	// UML图节点
 	UML string
 	// layer 信息
@@ -155,11 +222,28 @@ type structMeta struct {
 	scaned bool
 	// 是否是测试类
 	isTest bool
+	// 类型检查得到的类型, 没有做类型检查时为nil
+	typeName *types.TypeName
+	// 所在文件的构建约束, 只有AnnotateTags时才记录
+	buildConstraint string
+	// 泛型类型的类型参数
+	typeParams []string
+	// 是否是只能用作类型约束的接口
+	constraint bool
+	// 是否嵌入了没有扫描到的接口, 例如io.Reader, 这时方法集不完整
+	embedsUnknown bool
+	// 以这个类型定义的常量, 有常量时显示为enum
+	enumConsts []string
+	// 构造函数, 显示为静态方法
+	constructors []string
 }
 
 type typeAliasMeta struct {
 	baseInfo
-	Name           string
+	Name string
+	// 指向的具名类型, 泛型类型的实例指向泛型类型, 目标是基本类型或复合类型时为nil
+	target *typeKey
+	// 目标不是单纯的具名类型时, 带包路径的目标类型, 例如 []int, example.com/app/model.List[int]
 	targetTypeName string
 }
 
@@ -172,10 +256,30 @@ func (this *structMeta) ColorfulUML() string {
 		return " #MediumSpringGreen"
 	} else if this.category == StructCategory {
 		return " #LightSkyBlue"
+	} else if this.category == DefinedCategory {
+		return " #Wheat"
+	} else if this.category == AliasCategory {
+		return " #WhiteSmoke"
+	} else if this.category == VarCategory {
+		return " #Khaki"
+	} else if this.category == ExternalCategory {
+		return " #LightGrey"
 	}
 	return " #LightCyan"
 }
 
+// 约束接口和带构建约束的类型, 在UML中用stereotype标出来
+func (this *structMeta) StereotypeUML() string {
+	stereotype := ""
+	if this.constraint {
+		stereotype += " <<constraint>>"
+	}
+	if this.buildConstraint != "" {
+		stereotype += " <<" + this.buildConstraint + ">>"
+	}
+	return stereotype
+}
+
 func (this *structMeta) TextNote() string {
 	if this.isTest {
 		return " TEST"
@@ -183,12 +287,13 @@ func (this *structMeta) TextNote() string {
 	return ""
 }
 
-func (this *structMeta) needScan() bool {
-	return this.category == InterfaceCategory && !this.scaned
+// struct和其他定义的类型都可以有方法, 可以实现接口
+func (this *structMeta) isConcrete() bool {
+	return this.category == StructCategory || this.category == DefinedCategory
 }
 
-func (this *structMeta) implInterfaceUML(interfaceMeta1 *structMeta) string {
-	return fmt.Sprintf("%s <|.. %s\n", interfaceMeta1.UniqueNameUML(), this.UniqueNameUML())
+func (this *structMeta) needScan() bool {
+	return this.category == InterfaceCategory && !this.scaned
 }
 
 type importMeta struct {
@@ -202,82 +307,209 @@ type DependencyRelation struct {
 	source *structMeta
 	target *structMeta
 	uml    string
-	// 依赖关系是否是继承关系
-	inheritance bool
+	// 依赖关系的种类
+	kind RelationKind
+	// 嵌入的是否是指针, 只对继承关系有效
+	pointer bool
+	// 字段名, 只对字段和继承关系有效, 嵌入的字段名是类型名
+	fields []string
+	// 函数体中的依赖出现的位置, 例如 store/mem.go:12
+	positions []string
+	// 加入的先后顺序, 输出时保持稳定的顺序
+	index int
 }
 
 type analysisTool struct {
 	config Config
 
-	// 当前解析的go文件, 例如/appdev/go-demo/src/git.oschina.net/jscode/list-interface/a.go
-	currentFile string
-	// 当前解析的go文件,所在包路径, 例如git.oschina.net/jscode/list-interface
-	currentPackagePath string
-	// 当前解析的go文件,引入的其他包
-	currentFileImports []*importMeta
-
 	// 所有的struct
 	structMetas []*structMeta
-	// 所有的别名定义
+	// 所有的别名定义, type A = B
 	typeAliasMetas []*typeAliasMeta
 	// package path与package name的映射关系,例如git.oschina.net/jscode/list-interface 对应的pakcage name为 main
 	packagePathPackageNameCache map[string]string
 	// struct之间的依赖关系
 	dependencyRelations []*DependencyRelation
+	// structMetas, typeAliasMetas, dependencyRelations的索引
+	symbols *symbolTable
+	// struct/interface包括继承来的方法集, 解析完成后才使用
+	methodSetCache map[*structMeta]*methodSet
+	// 接收者字段的方法调用, 所有文件合并后再确定依赖的类型
+	fieldCalls []*pendingFieldCall
+	// 包级别的变量, 所有文件合并后再确定类型
+	vars []*pendingVar
+	// var _ I = (*T)(nil) 形式的实现关系断言
+	assertions []*implAssertion
+	// 代码目录中的包
+	scannedPackages map[string]bool
+	// ShowExternal时, 代码目录以外的类型的节点
+	externals     map[typeKey]*structMeta
+	externalMutex sync.Mutex
+	// 目录与所属go模块的映射关系
+	moduleCache map[string]*moduleMeta
+	// 解析过程中发现的问题
+	diagnostics []*Diagnostic
+	// 需要扫描的代码目录
+	codeDirs []string
+	// 判断文件是否参与编译的构建环境
+	buildContext build.Context
+	// go文件与其构建约束的映射关系, 只包括当前构建环境下参与编译的文件
+	fileConstraints map[string]string
+	// 解析时所有go文件的修改时间, 包括不参与编译的文件, 监视模式下用来判断文件是否变化
+	fileStamps map[string]fileStamp
+	// 解析结果的缓存, 为nil时不使用缓存
+	facts *factCache
+
+	// 所有go文件共用的FileSet
+	fset *token.FileSet
+	// 解析过的go文件, 按遍历顺序排列
+	parsedFiles []*parsedFile
+	// 读取成功的所有go文件, 包括不参与编译的文件, 监视模式下没有变化的目录直接使用
+	files map[string]*parsedFile
+	// 监视模式下上次解析的, 没有变化的目录中的文件, 不再重新读取和解析
+	reusableFiles map[string]*parsedFile
+	// 类型检查的结果
+	typesInfo *types.Info
+	// 类型检查时导入外部包, 为nil时在类型检查时创建
+	importer *sourceImporter
 }
 
 func (this *analysisTool) analysis(config Config) {
 
 	this.config = config
 
+	if this.config.CodeDir == "" {
+		this.config.CodeDir = this.config.WorkspaceDir
+	}
+
 	if this.config.CodeDir == "" || !PathExists(this.config.CodeDir) {
 		log.Errorf("找不到代码目录%s\n", this.config.CodeDir)
 		return
 	}
 
-	if this.config.GopathDir == "" || !PathExists(this.config.GopathDir) {
+	if this.config.GopathDir != "" && !PathExists(this.config.GopathDir) {
 		log.Errorf("找不到GOPATH目录%s\n", this.config.GopathDir)
 		return
 	}
 
-	for _, lib := range stdlibs {
-		this.mapPackagePath_PackageName(lib, path.Base(lib))
+	// 查找go.mod时要向上查找父目录, 相对路径例如 . 找不到父目录; 包路径按GOPATH前缀计算, 也要用绝对路径
+	this.config.CodeDir = absDir(this.config.CodeDir)
+	if this.config.WorkspaceDir != "" {
+		this.config.WorkspaceDir = absDir(this.config.WorkspaceDir)
+	}
+	if this.config.GopathDir != "" {
+		this.config.GopathDir = absDir(this.config.GopathDir)
+	}
+	// 遍历得到的是绝对路径, 排除目录和vendor目录按前缀比较, 也要用绝对路径
+	if this.config.VendorDir != "" {
+		this.config.VendorDir = absDir(this.config.VendorDir)
+	}
+	ignoreDirs := make([]string, 0, len(this.config.IgnoreDirs))
+	for _, dir := range this.config.IgnoreDirs {
+		ignoreDirs = append(ignoreDirs, absDir(dir))
+	}
+	this.config.IgnoreDirs = ignoreDirs
+
+	for lib, name := range discoverStdlibs() {
+		this.mapPackagePath_PackageName(lib, name)
 	}
 
-	dir_walk_once := func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			if strings.HasPrefix(info.Name(), ".") || HasPrefixInSomeElement(path, config.IgnoreDirs, config.VendorDir) {
-				return filepath.SkipDir
-			}
-		}
+	this.initCodeDirs()
+	this.initBuildContext()
 
-		if strings.HasSuffix(path, ".go") {
-			isTest := this.checkIsTest(path)
-			log.Info("解析1 " + path)
-			this.visitTypeInFile(path, isTest)
+	if this.facts != nil && this.facts.dir != "" {
+		if err := os.MkdirAll(this.facts.dir, 0777); err != nil {
+			log.Errorf("创建缓存目录%s失败, %s\n", this.facts.dir, err)
+			this.facts.dir = ""
 		}
+	}
 
-		return nil
+	this.parseFiles()
+
+	contexts := make([]*fileContext, len(this.parsedFiles))
+	for i, file := range this.parsedFiles {
+		contexts[i] = this.newFileContext(file)
 	}
 
-	filepath.Walk(config.CodeDir, dir_walk_once)
+	this.runJobs(len(contexts), func(i int) {
+		contexts[i].visitTypeInFile()
+	})
+	for _, ctx := range contexts {
+		this.mergeTypes(ctx)
+	}
 
-	dir_walk_twice := func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			if strings.HasPrefix(info.Name(), ".") || HasPrefixInSomeElement(path, config.IgnoreDirs, config.VendorDir) {
-				return filepath.SkipDir
-			}
-		}
+	if this.config.TypeCheck {
+		this.typeCheck()
+	}
 
-		if strings.HasSuffix(path, ".go") {
-			log.Info("解析2 " + path)
-			this.visitFuncInFile(path)
-		}
-		return nil
+	this.runJobs(len(contexts), func(i int) {
+		contexts[i].visitAliasInFile()
+	})
+	for _, ctx := range contexts {
+		this.mergeAliases(ctx)
+	}
+	if this.config.ShowAlias {
+		this.addAliasNodes()
+	}
+
+	this.runJobs(len(contexts), func(i int) {
+		contexts[i].visitFuncInFile()
+	})
+	for _, ctx := range contexts {
+		this.mergeFuncs(ctx)
+	}
+	this.addExternalStubs()
+
+	this.resolveFieldCalls()
+	this.resolveVars()
+	this.checkAssertions()
+
+	this.renderEnums()
+	this.renderConstructors()
+	this.renderBodyRelations()
+	this.dedupeDiagnostics()
+
+	if this.facts != nil {
+		this.facts.prune()
 	}
 
-	filepath.Walk(config.CodeDir, dir_walk_twice)
+}
+
+// 遍历所有代码目录中的go文件并记录修改时间, 构建约束在读取文件内容后再判断
+func (this *analysisTool) walkGoFiles(visit func(path string)) {
+	this.walkAllGoFiles(func(path string, info os.FileInfo) {
+		this.fileStamps[path] = newFileStamp(info)
+		visit(path)
+	})
+}
+
+// 遍历所有代码目录中的go文件, 不考虑构建约束
+func (this *analysisTool) walkAllGoFiles(visit func(path string, info os.FileInfo)) {
 
+	for _, root := range this.codeDirs {
+
+		dir_walk := func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// 遍历过程中被删除的文件
+				return nil
+			}
+
+			if info.IsDir() {
+				if this.skipDir(root, path, info) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if strings.HasSuffix(path, ".go") {
+				visit(path, info)
+			}
+
+			return nil
+		}
+
+		filepath.Walk(root, dir_walk)
+	}
 }
 
 func (this *analysisTool) checkIsTest(s string) bool {
@@ -293,22 +525,10 @@ func (this *analysisTool) checkIsTest(s string) bool {
 	return false
 }
 
-func (this *analysisTool) initFile(path string) {
-	log.Debug("path=", path)
-
-	this.currentFile = path
-	this.currentPackagePath = this.filepathToPackagePath(path)
-
-	if this.currentPackagePath == "" {
-		log.Errorf("packagePath为空,currentFile=%s\n", this.currentFile)
-	}
-
-}
-
 func (this *analysisTool) mapPackagePath_PackageName(packagePath string, packageName string) {
 	if packagePath == "" || packageName == "" {
-		log.Errorf("mapPackagePath_PackageName, packageName=%s, packagePath=%s\n, current_file=%s",
-			packageName, packagePath, this.currentFile)
+		log.Errorf("mapPackagePath_PackageName, packageName=%s, packagePath=%s\n",
+			packageName, packagePath)
 		return
 	}
 
@@ -321,19 +541,16 @@ func (this *analysisTool) mapPackagePath_PackageName(packagePath string, package
 
 }
 
-func (this *analysisTool) visitTypeInFile(path string, isTest bool) {
+func (this *fileContext) visitTypeInFile() {
 
-	this.initFile(path)
+	file := this.file.file
+	isTest := this.file.isTest
 
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
-
-	if err != nil {
-		log.Fatal(err)
-		return
+	if this.currentPackagePath == "" {
+		this.addDiagnostic(UnknownPackageDiagnostic, ErrorSeverity, token.NoPos, "无法确认包路径名")
 	}
 
-	this.mapPackagePath_PackageName(this.currentPackagePath, file.Name.Name)
+	this.packageName = file.Name.Name
 
 	for _, decl := range file.Decls {
 
@@ -356,27 +573,41 @@ func (this *analysisTool) visitTypeInFile(path string, isTest bool) {
 
 }
 
-func (this *analysisTool) visitTypeSpec(typeSpec *ast.TypeSpec, isTest bool) {
+func (this *fileContext) visitTypeSpec(typeSpec *ast.TypeSpec, isTest bool) {
+
+	typeParams := typeParamNames(typeSpec.TypeParams)
 
 	interfaceType, ok := typeSpec.Type.(*ast.InterfaceType)
 	if ok {
-		this.visitInterfaceType(typeSpec.Name.Name, interfaceType)
+		this.visitInterfaceType(typeSpec.Name.Name, typeParams, interfaceType)
 		return
 	}
 
 	structType, ok := typeSpec.Type.(*ast.StructType)
 	if ok {
-		this.visitStructType(typeSpec.Name.Name, structType, isTest)
+		this.visitStructType(typeSpec.Name.Name, typeParams, structType, isTest)
 		return
 	}
 
-	// 其他类型别名
-	this.typeAliasMetas = append(this.typeAliasMetas, &typeAliasMeta{
+	// type A = B, 真正的类型别名
+	if typeSpec.Assign.IsValid() {
+		this.visitTypeAlias(typeSpec.Name.Name)
+		return
+	}
+
+	// type Status int, type HandlerFunc func() 等其他定义的类型
+	this.visitDefinedType(typeSpec.Name.Name, typeParams, isTest)
+
+}
+
+func (this *fileContext) visitTypeAlias(name string) {
+
+	this.newTypeAliasMetas = append(this.newTypeAliasMetas, &typeAliasMeta{
 		baseInfo: baseInfo{
 			FilePath:    this.currentFile,
 			PackagePath: this.currentPackagePath,
 		},
-		Name:           typeSpec.Name.Name,
+		Name:           name,
 		targetTypeName: "",
 	})
 
@@ -394,6 +625,10 @@ func (this *analysisTool) filepathToPackagePath(filepath string) string {
 		}
 	}
 
+	if packagePath := this.dirToModulePackagePath(filepath); packagePath != "" {
+		return packagePath
+	}
+
 	if this.config.GopathDir != "" {
 		srcdir := path.Join(this.config.GopathDir, "src")
 		if strings.HasPrefix(filepath, srcdir) {
@@ -403,23 +638,16 @@ func (this *analysisTool) filepathToPackagePath(filepath string) string {
 		}
 	}
 
-	log.Errorf("无法确认包路径名, filepath=%s\n", filepath)
+	log.Debugf("无法确认包路径名, filepath=%s\n", filepath)
 
 	return ""
 
 }
 
-func (this *analysisTool) visitFuncInFile(path string) {
+// 当前文件引入的包, 所有包名都已知后才能确定没有指定别名的import的包名
+func (this *fileContext) visitImports() {
 
-	this.initFile(path)
-
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
-
-	if err != nil {
-		log.Fatal(err)
-		return
-	}
+	file := this.file.file
 
 	this.currentFileImports = []*importMeta{}
 
@@ -450,6 +678,12 @@ func (this *analysisTool) visitFuncInFile(path string) {
 			})
 		}
 	}
+}
+
+// 第二遍解析: 方法, 字段等依赖关系. 引入的包在解析别名时已经确定
+func (this *fileContext) visitFuncInFile() {
+
+	file := this.file.file
 
 	for _, decl := range file.Decls {
 
@@ -465,6 +699,8 @@ func (this *analysisTool) visitFuncInFile(path string) {
 						continue
 					}
 
+					this.typeParams = typeParamNames(typeSpec.TypeParams)
+
 					interfaceType, ok := typeSpec.Type.(*ast.InterfaceType)
 					if ok {
 						this.visitInterfaceFunctions(typeSpec.Name.Name, interfaceType)
@@ -475,8 +711,23 @@ func (this *analysisTool) visitFuncInFile(path string) {
 						this.visitStructFields(typeSpec.Name.Name, structType)
 					}
 
+					definedMeta1, ok := this.fileStructs[typeSpec.Name.Name]
+					if ok && definedMeta1.category == DefinedCategory {
+						this.visitDefinedTypeUnderlying(typeSpec.Name.Name, typeSpec.Type)
+					}
+
+					this.typeParams = nil
+
 				}
 			}
+
+			if genDecl.Tok == token.CONST {
+				this.visitConstDecl(genDecl)
+			}
+
+			if genDecl.Tok == token.VAR {
+				this.visitVarDecl(genDecl)
+			}
 		}
 
 	}
@@ -492,7 +743,7 @@ func (this *analysisTool) visitFuncInFile(path string) {
 
 }
 
-func (this *analysisTool) visitStructType(name string, structType *ast.StructType, isTest bool) {
+func (this *fileContext) visitStructType(name string, typeParams []string, structType *ast.StructType, isTest bool) {
 
 	strutMeta1 := &structMeta{
 		baseInfo: baseInfo{
@@ -503,15 +754,76 @@ func (this *analysisTool) visitStructType(name string, structType *ast.StructTyp
 		MethodSigns: []string{},
 		category:    StructCategory,
 		isTest:      isTest,
+		typeParams:  typeParams,
 	}
+	this.annotateBuildConstraint(strutMeta1)
 
-	this.structMetas = append(this.structMetas, strutMeta1)
+	this.newStructMetas = append(this.newStructMetas, strutMeta1)
+	this.fileStructs[name] = strutMeta1
 
 }
 
-func (this *analysisTool) visitStructFields(structName string, structType *ast.StructType) {
+func (this *fileContext) visitDefinedType(name string, typeParams []string, isTest bool) {
 
-	sourceStruct1 := this.findStruct(this.currentPackagePath, structName)
+	definedMeta1 := &structMeta{
+		baseInfo: baseInfo{
+			FilePath:    this.currentFile,
+			PackagePath: this.currentPackagePath,
+		},
+		Name:        name,
+		MethodSigns: []string{},
+		category:    DefinedCategory,
+		isTest:      isTest,
+		typeParams:  typeParams,
+	}
+	this.annotateBuildConstraint(definedMeta1)
+
+	this.newStructMetas = append(this.newStructMetas, definedMeta1)
+	this.fileStructs[name] = definedMeta1
+
+}
+
+// 定义的类型依赖它的底层类型, 例如 type Peers []*Peer 依赖Peer
+func (this *fileContext) visitDefinedTypeUnderlying(name string, t ast.Expr) {
+
+	definedMeta1 := this.fileStructs[name]
+
+	definedMeta1.UML = this.definedTypeToUML(name, t, definedMeta1)
+
+	added := map[*structMeta]bool{}
+
+	targets := append([]ast.Expr{t}, typeArguments(t)...)
+	for _, target := range targets {
+
+		targetStruct1, isarray := this.analysisTypeForDependencyRelation(target)
+		if targetStruct1 == nil || added[targetStruct1] {
+			continue
+		}
+		added[targetStruct1] = true
+
+		uml := definedMeta1.UniqueNameUML() + " "
+		if target == t {
+			uml += this.mapQualifierUML(t)
+		}
+		uml += "---> "
+		if isarray {
+			uml += "\"*\" "
+		}
+		uml += targetStruct1.UniqueNameUML()
+
+		this.newRelations = append(this.newRelations, &DependencyRelation{
+			source: definedMeta1,
+			target: targetStruct1,
+			uml:    uml,
+		})
+	}
+
+	this.addMapKeyRelation(definedMeta1, t, "", added)
+}
+
+func (this *fileContext) visitStructFields(structName string, structType *ast.StructType) {
+
+	sourceStruct1 := this.fileStructs[structName]
 
 	sourceStruct1.UML = this.structToUML(structName, structType, sourceStruct1)
 
@@ -520,7 +832,7 @@ func (this *analysisTool) visitStructFields(structName string, structType *ast.S
 	}
 }
 
-func (this *analysisTool) visitStructField(sourceStruct1 *structMeta, field *ast.Field) {
+func (this *fileContext) visitStructField(sourceStruct1 *structMeta, field *ast.Field) {
 
 	fieldNames := this.IdentsToString(field.Names)
 
@@ -530,14 +842,18 @@ func (this *analysisTool) visitStructField(sourceStruct1 *structMeta, field *ast
 
 		if fieldNames == "" {
 
+			_, pointer := field.Type.(*ast.StarExpr)
+
 			d := DependencyRelation{
-				source:      sourceStruct1,
-				target:      targetStruct1,
-				uml:         sourceStruct1.UniqueNameUML() + " -|> " + targetStruct1.UniqueNameUML(),
-				inheritance: true,
+				source:  sourceStruct1,
+				target:  targetStruct1,
+				uml:     sourceStruct1.UniqueNameUML() + " -|> " + targetStruct1.UniqueNameUML(),
+				kind:    InheritanceRelation,
+				pointer: pointer,
+				fields:  []string{embeddedFieldName(field.Type)},
 			}
 
-			this.dependencyRelations = append(this.dependencyRelations, &d)
+			this.newRelations = append(this.newRelations, &d)
 
 		} else {
 
@@ -546,19 +862,21 @@ func (this *analysisTool) visitStructField(sourceStruct1 *structMeta, field *ast
 				d := DependencyRelation{
 					source: sourceStruct1,
 					target: targetStruct1,
-					uml:    sourceStruct1.UniqueNameUML() + " ---> \"*\" " + targetStruct1.UniqueNameUML() + " : " + fieldNames,
+					uml:    sourceStruct1.UniqueNameUML() + " " + this.mapQualifierUML(field.Type) + "---> \"*\" " + targetStruct1.UniqueNameUML() + " : " + fieldNames,
+					fields: identNames(field.Names),
 				}
 
-				this.dependencyRelations = append(this.dependencyRelations, &d)
+				this.newRelations = append(this.newRelations, &d)
 
 			} else {
 				d := DependencyRelation{
 					source: sourceStruct1,
 					target: targetStruct1,
 					uml:    sourceStruct1.UniqueNameUML() + " ---> " + targetStruct1.UniqueNameUML() + " : " + fieldNames,
+					fields: identNames(field.Names),
 				}
 
-				this.dependencyRelations = append(this.dependencyRelations, &d)
+				this.newRelations = append(this.newRelations, &d)
 
 			}
 
@@ -566,27 +884,59 @@ func (this *analysisTool) visitStructField(sourceStruct1 *structMeta, field *ast
 
 	}
 
+	// 泛型类型的实参, 例如 List[User] 除了依赖List, 也依赖User
+	added := map[*structMeta]bool{targetStruct1: true}
+
+	this.addMapKeyRelation(sourceStruct1, field.Type, fieldNames, added)
+
+	for _, typeArgument := range typeArguments(field.Type) {
+
+		argStruct1, isarray := this.analysisTypeForDependencyRelation(typeArgument)
+		if argStruct1 == nil || added[argStruct1] {
+			continue
+		}
+		added[argStruct1] = true
+
+		uml := sourceStruct1.UniqueNameUML() + " ---> "
+		if isarray {
+			uml += "\"*\" "
+		}
+		uml += argStruct1.UniqueNameUML()
+		if fieldNames != "" {
+			uml += " : " + fieldNames
+		}
+
+		this.newRelations = append(this.newRelations, &DependencyRelation{
+			source: sourceStruct1,
+			target: argStruct1,
+			uml:    uml,
+		})
+	}
+
 }
 
+// 泛型引入的预声明标识符, 和基本类型一样没有包路径
+var predeclaredConstraints = []string{"any", "comparable"}
+
 func (this *analysisTool) isGoBaseType(type1 string) bool {
 
 	baseTypes := []string{"bool", "byte", "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64",
 		"float32", "float64", "complex64", "complex128", "string", "uintptr", "rune", "error"}
 
-	if sliceContains(baseTypes, type1) {
+	if sliceContains(baseTypes, type1) || sliceContains(predeclaredConstraints, type1) {
 		return true
 	}
 
 	return false
 }
 
-func (this *analysisTool) findStructByAliasAndStructName(alias string, structName string) *structMeta {
+func (this *fileContext) findStructByAliasAndStructName(alias string, structName string, pos token.Pos) *structMeta {
 
-	if alias == "" && this.isGoBaseType(structName) {
+	if alias == "" && (this.isGoBaseType(structName) || sliceContains(this.typeParams, structName)) {
 		return nil
 	}
 
-	packagepath := this.findPackagePathByAlias(alias, structName)
+	packagepath := this.findPackagePathByAlias(alias, structName, pos)
 
 	if packagepath != "" {
 		return this.findStruct(packagepath, structName)
@@ -595,14 +945,22 @@ func (this *analysisTool) findStructByAliasAndStructName(alias string, structNam
 	return nil
 }
 
-func (this *analysisTool) analysisTypeForDependencyRelation(t ast.Expr) (structMeta1 *structMeta, isArray bool) {
+func (this *fileContext) analysisTypeForDependencyRelation(t ast.Expr) (structMeta1 *structMeta, isArray bool) {
 
 	structMeta1 = nil
 	isArray = false
 
+	if meta, ok := this.findStructByTypes(t); ok {
+		structMeta1 = meta
+		if structMeta1 == nil {
+			structMeta1 = this.externalStubByTypes(t)
+		}
+		return
+	}
+
 	ident, ok := t.(*ast.Ident)
 	if ok {
-		structMeta1 = this.findStructByAliasAndStructName("", ident.Name)
+		structMeta1 = this.findStructByAliasAndStructName("", ident.Name, ident.Pos())
 		isArray = false
 		return
 	}
@@ -629,19 +987,42 @@ func (this *analysisTool) analysisTypeForDependencyRelation(t ast.Expr) (structM
 		return
 	}
 
+	chanType, ok := t.(*ast.ChanType)
+	if ok {
+		eleStructName, _ := this.analysisTypeForDependencyRelation(chanType.Value)
+		structMeta1 = eleStructName
+		isArray = true
+		return
+	}
+
 	selectorExpr, ok := t.(*ast.SelectorExpr)
 	if ok {
 		alias := this.typeToString(selectorExpr.X, false)
-		structMeta1 = this.findStructByAliasAndStructName(alias, this.typeToString(selectorExpr.Sel, false))
+		structMeta1 = this.findStructByAliasAndStructName(alias, this.typeToString(selectorExpr.Sel, false), selectorExpr.Pos())
+		if structMeta1 == nil {
+			structMeta1 = this.externalStub(this.importPath(alias), selectorExpr.Sel.Name)
+		}
 		isArray = false
 		return
 	}
 
+	// 泛型类型的实例, 例如 List[User], 依赖的是List
+	if genericType, typeArguments := splitTypeArguments(t); typeArguments != nil {
+		structMeta1, isArray = this.analysisTypeForDependencyRelation(genericType)
+		return
+	}
+
 	return
 }
 
-func (this *analysisTool) structToUML(name string, structType *ast.StructType, me *structMeta) string {
-	classUML := "class " + name + me.ColorfulUML() + " " + this.structBodyToString(structType)
+func (this *fileContext) structToUML(name string, structType *ast.StructType, me *structMeta) string {
+	classUML := "class " + name + me.TypeParamsUML() + me.StereotypeUML() + me.ColorfulUML() + " " + this.structBodyToString(structType)
+	return fmt.Sprintf("namespace %s {\n %s \n}", this.packagePathToUML(this.currentPackagePath), classUML)
+}
+
+// 定义的类型显示为class, 内容是底层类型
+func (this *fileContext) definedTypeToUML(name string, t ast.Expr, me *structMeta) string {
+	classUML := "class " + name + me.TypeParamsUML() + me.StereotypeUML() + me.ColorfulUML() + " {\n  " + this.typeToString(t, false) + "\n}"
 	return fmt.Sprintf("namespace %s {\n %s \n}", this.packagePathToUML(this.currentPackagePath), classUML)
 }
 
@@ -649,7 +1030,7 @@ func (this *analysisTool) packagePathToUML(packagePath string) string {
 	return packagePathToUML(packagePath)
 }
 
-func (this *analysisTool) structBodyToString(structType *ast.StructType) string {
+func (this *fileContext) structBodyToString(structType *ast.StructType) string {
 
 	result := "{\n"
 
@@ -663,26 +1044,30 @@ func (this *analysisTool) structBodyToString(structType *ast.StructType) string
 
 }
 
-func (this *analysisTool) visitInterfaceType(name string, interfaceType *ast.InterfaceType) {
+func (this *fileContext) visitInterfaceType(name string, typeParams []string, interfaceType *ast.InterfaceType) {
 
 	interfaceInfo1 := &structMeta{
 		baseInfo: baseInfo{
 			FilePath:    this.currentFile,
 			PackagePath: this.currentPackagePath,
 		},
-		Name:     name,
-		category: InterfaceCategory,
+		Name:       name,
+		category:   InterfaceCategory,
+		typeParams: typeParams,
+		constraint: this.isConstraintInterface(interfaceType),
 	}
+	this.annotateBuildConstraint(interfaceInfo1)
 
-	this.structMetas = append(this.structMetas, interfaceInfo1)
+	this.newStructMetas = append(this.newStructMetas, interfaceInfo1)
+	this.fileStructs[name] = interfaceInfo1
 }
 
-func (this *analysisTool) interfaceToUML(name string, interfaceType *ast.InterfaceType, me *structMeta) string {
-	interfaceUML := "interface " + name + me.ColorfulUML() + " " + this.interfaceBodyToString(interfaceType)
+func (this *fileContext) interfaceToUML(name string, interfaceType *ast.InterfaceType, me *structMeta) string {
+	interfaceUML := "interface " + name + me.TypeParamsUML() + me.StereotypeUML() + me.ColorfulUML() + " " + this.interfaceBodyToString(interfaceType)
 	return fmt.Sprintf("namespace %s {\n %s \n}", this.packagePathToUML(this.currentPackagePath), interfaceUML)
 }
 
-func (this *analysisTool) funcParamsResultsToString(funcType *ast.FuncType) string {
+func (this *fileContext) funcParamsResultsToString(funcType *ast.FuncType) string {
 
 	funcString := "("
 
@@ -721,33 +1106,41 @@ func (this *analysisTool) funcParamsResultsToString(funcType *ast.FuncType) stri
 
 }
 
+// 别名按它指向的类型查找, 例如 type Client = internal.Client 中的Client找到的是internal.Client
 func (this *analysisTool) findStruct(packagePath string, structName string) *structMeta {
 
-	for _, structMeta1 := range this.structMetas {
-		if structMeta1.Name == structName && structMeta1.PackagePath == packagePath {
-			return structMeta1
+	key := typeKey{PackagePath: packagePath, Name: structName}
+
+	// 别名可以指向另一个别名, 限制层数避免循环
+	for i := 0; i < maxAliasDepth; i++ {
+		if meta, ok := this.symbols.structs[key]; ok {
+			return meta
+		}
+
+		alias, ok := this.symbols.typeAliass[key]
+		if !ok || alias.target == nil {
+			return nil
 		}
+		key = *alias.target
 	}
 
 	return nil
 }
 
 func (this *analysisTool) findTypeAlias(packagePath string, structName string) *typeAliasMeta {
-
-	for _, typeAliasMeta1 := range this.typeAliasMetas {
-		if typeAliasMeta1.Name == structName && typeAliasMeta1.PackagePath == packagePath {
-			return typeAliasMeta1
-		}
-	}
-
-	return nil
+	return this.symbols.typeAliass[typeKey{PackagePath: packagePath, Name: structName}]
 }
 
-func (this *analysisTool) visitFunc(funcDecl *ast.FuncDecl) {
+func (this *fileContext) visitFunc(funcDecl *ast.FuncDecl) {
 
 	this.debugFunc(funcDecl)
 
-	packageAlias, structName := this.findStructTypeOfFunc(funcDecl)
+	packageAlias, structName, typeParams, pointer := this.findStructTypeOfFunc(funcDecl)
+
+	this.typeParams = typeParams
+	defer func() {
+		this.typeParams = nil
+	}()
 
 	if structName != "" {
 
@@ -759,18 +1152,31 @@ func (this *analysisTool) visitFunc(funcDecl *ast.FuncDecl) {
 		structMeta := this.findStruct(packagePath, structName)
 		if structMeta != nil {
 			methodSign := this.createMethodSign(funcDecl.Name.Name, funcDecl.Type)
-			structMeta.MethodSigns = append(structMeta.MethodSigns, methodSign)
+			this.newMethodSigns = append(this.newMethodSigns, &pendingMethodSign{
+				meta:    structMeta,
+				sign:    methodSign,
+				pointer: pointer,
+			})
+			this.visitMethodUses(structMeta, funcDecl.Type)
+			this.visitFuncBody(structMeta, receiverName(funcDecl), funcDecl.Body)
+		}
+	}
+
+	if funcDecl.Recv == nil {
+		if meta := this.visitConstructor(funcDecl); meta != nil {
+			this.visitFuncBody(meta, "", funcDecl.Body)
 		}
 	}
 
 }
 
-func (this *analysisTool) visitInterfaceFunctions(name string, interfaceType *ast.InterfaceType) {
+func (this *fileContext) visitInterfaceFunctions(name string, interfaceType *ast.InterfaceType) {
 
 	if name == "CryptoSuite" {
 		log.Println("haha")
 	}
 	methods := []string{}
+	im := this.fileStructs[name]
 
 	for _, field := range interfaceType.Methods.List {
 
@@ -778,15 +1184,43 @@ func (this *analysisTool) visitInterfaceFunctions(name string, interfaceType *as
 
 		if ok {
 			methods = append(methods, this.createMethodSign(field.Names[0].Name, funcType))
+			this.visitMethodUses(im, funcType)
+		} else if len(field.Names) == 0 && !this.isTypeElement(field.Type) {
+			this.visitEmbeddedInterface(im, field.Type)
 		}
 	}
 
-	im := this.findStruct(this.currentPackagePath, name)
 	im.MethodSigns = methods
 	im.UML = this.interfaceToUML(name, interfaceType, im)
 }
 
-func (this *analysisTool) findStructTypeOfFunc(funcDecl *ast.FuncDecl) (packageAlias string, structName string) {
+// 接口中嵌入的接口, 作为接口之间的继承关系, 计算方法集时会包括被嵌入接口的方法
+func (this *fileContext) visitEmbeddedInterface(im *structMeta, t ast.Expr) {
+
+	// 嵌入any不增加任何方法, 方法集仍然是完整的
+	ident, ok := t.(*ast.Ident)
+	if ok && ident.Name == "any" {
+		return
+	}
+
+	targetInterface1, _ := this.analysisTypeForDependencyRelation(t)
+	if targetInterface1 == nil || targetInterface1.category != InterfaceCategory {
+		im.embedsUnknown = true
+		// ShowExternal时外部接口的方法集仍然未知, 但是要画出嵌入关系, 不能只留下孤立的节点
+		if targetInterface1 == nil || targetInterface1.category != ExternalCategory {
+			return
+		}
+	}
+
+	this.newRelations = append(this.newRelations, &DependencyRelation{
+		source: im,
+		target: targetInterface1,
+		uml:    im.UniqueNameUML() + " -|> " + targetInterface1.UniqueNameUML(),
+		kind:   InheritanceRelation,
+	})
+}
+
+func (this *analysisTool) findStructTypeOfFunc(funcDecl *ast.FuncDecl) (packageAlias string, structName string, typeParams []string, pointer bool) {
 
 	if funcDecl.Recv != nil {
 
@@ -794,20 +1228,25 @@ func (this *analysisTool) findStructTypeOfFunc(funcDecl *ast.FuncDecl) (packageA
 
 			t := field.Type
 
-			ident, ok := t.(*ast.Ident)
+			starExpr, ok := t.(*ast.StarExpr)
 			if ok {
-				packageAlias = ""
-				structName = ident.Name
+				t = starExpr.X
+				pointer = true
 			}
 
-			starExpr, ok := t.(*ast.StarExpr)
-			if ok {
-				ident, ok := starExpr.X.(*ast.Ident)
+			// 泛型类型的方法, 例如 func (c *Cache[K, V]) Get(k K) V
+			t, typeArguments := splitTypeArguments(t)
+			for _, typeArgument := range typeArguments {
+				ident, ok := typeArgument.(*ast.Ident)
 				if ok {
-					packageAlias = ""
-					structName = ident.Name
+					typeParams = append(typeParams, ident.Name)
 				}
+			}
 
+			ident, ok := t.(*ast.Ident)
+			if ok {
+				packageAlias = ""
+				structName = ident.Name
 			}
 		}
 	}
@@ -852,10 +1291,15 @@ func (this *analysisTool) IdentsToString(names []*ast.Ident) string {
 }
 
 // 创建方法签名
-func (this *analysisTool) createMethodSign(methodName string, funcType *ast.FuncType) string {
+func (this *fileContext) createMethodSign(methodName string, funcType *ast.FuncType) string {
 
 	methodSign := methodName + "("
 
+	// 不导出的方法属于定义它的包, 其他包的类型无法实现
+	if !ast.IsExported(methodName) {
+		methodSign = this.currentPackagePath + "." + methodSign
+	}
+
 	if funcType.Params != nil {
 		for index, field := range funcType.Params.List {
 			if index != 0 {
@@ -888,7 +1332,7 @@ func (this *analysisTool) createMethodSign(methodName string, funcType *ast.Func
 	return methodSign
 }
 
-func (this *analysisTool) fieldToStringInMethodSign(f *ast.Field) string {
+func (this *fileContext) fieldToStringInMethodSign(f *ast.Field) string {
 
 	argCount := len(f.Names)
 
@@ -908,7 +1352,7 @@ func (this *analysisTool) fieldToStringInMethodSign(f *ast.Field) string {
 	return sign
 }
 
-func (this *analysisTool) fieldToString(f *ast.Field) string {
+func (this *fileContext) fieldToString(f *ast.Field) string {
 
 	r := ""
 
@@ -932,7 +1376,13 @@ func (this *analysisTool) fieldToString(f *ast.Field) string {
 
 }
 
-func (this *analysisTool) typeToString(t ast.Expr, convertTypeToUnqiueType bool) string {
+func (this *fileContext) typeToString(t ast.Expr, convertTypeToUnqiueType bool) string {
+
+	if convertTypeToUnqiueType {
+		if name, ok := this.uniqueTypeNameByTypes(t); ok {
+			return name
+		}
+	}
 
 	ident, ok := t.(*ast.Ident)
 	if ok {
@@ -976,7 +1426,7 @@ func (this *analysisTool) typeToString(t ast.Expr, convertTypeToUnqiueType bool)
 	selectorExpr, ok := t.(*ast.SelectorExpr)
 	if ok {
 		if convertTypeToUnqiueType {
-			return this.findPackagePathByAlias(this.selectorExprToString(selectorExpr.X), selectorExpr.Sel.Name) + "." + selectorExpr.Sel.Name
+			return this.uniqueTypeName(this.findPackagePathByAlias(this.selectorExprToString(selectorExpr.X), selectorExpr.Sel.Name, selectorExpr.Pos()), selectorExpr.Sel.Name)
 		} else {
 			return this.typeToString(selectorExpr.X, true) + "." + selectorExpr.Sel.Name
 		}
@@ -997,24 +1447,47 @@ func (this *analysisTool) typeToString(t ast.Expr, convertTypeToUnqiueType bool)
 		return " (" + this.typeToString(parenExpr.X, convertTypeToUnqiueType) + ")"
 	}
 
-	log.Error("typeToString ", reflect.TypeOf(t), " file=", this.currentFile, " expr=", this.content(t))
+	if genericType, typeArguments := splitTypeArguments(t); typeArguments != nil {
+		args := make([]string, 0, len(typeArguments))
+		for _, typeArgument := range typeArguments {
+			args = append(args, this.typeToString(typeArgument, convertTypeToUnqiueType))
+		}
+		return this.typeToString(genericType, convertTypeToUnqiueType) + "[" + strings.Join(args, ", ") + "]"
+	}
+
+	// 类型约束, 例如 ~int | ~string
+	unaryExpr, ok := t.(*ast.UnaryExpr)
+	if ok && unaryExpr.Op == token.TILDE {
+		return "~" + this.typeToString(unaryExpr.X, convertTypeToUnqiueType)
+	}
+
+	binaryExpr, ok := t.(*ast.BinaryExpr)
+	if ok && binaryExpr.Op == token.OR {
+		return this.typeToString(binaryExpr.X, convertTypeToUnqiueType) + " | " + this.typeToString(binaryExpr.Y, convertTypeToUnqiueType)
+	}
+
+	this.addDiagnostic(UnsupportedExprDiagnostic, WarningSeverity, t.Pos(), "typeToString不支持%s, expr=%s", reflect.TypeOf(t), this.content(t))
 
 	return ""
 }
 
-func (this *analysisTool) selectorExprToString(t ast.Expr) string {
+func (this *fileContext) selectorExprToString(t ast.Expr) string {
 
 	ident, ok := t.(*ast.Ident)
 	if ok {
 		return ident.Name
 	}
 
-	log.Error("selectorExprToString ", reflect.TypeOf(t), " file=", this.currentFile, " expr=", this.content(t))
+	this.addDiagnostic(UnsupportedExprDiagnostic, WarningSeverity, t.Pos(), "selectorExprToString不支持%s, expr=%s", reflect.TypeOf(t), this.content(t))
 
 	return ""
 }
 
-func (this *analysisTool) addPackagePathWhenStruct(fieldType string) string {
+func (this *fileContext) addPackagePathWhenStruct(fieldType string) string {
+
+	if sliceContains(this.typeParams, fieldType) {
+		return fieldType
+	}
 
 	searchPackages := []string{this.currentPackagePath}
 
@@ -1024,16 +1497,16 @@ func (this *analysisTool) addPackagePathWhenStruct(fieldType string) string {
 		}
 	}
 
-	for _, meta := range this.structMetas {
-		if sliceContains(searchPackages, meta.PackagePath) && meta.Name == fieldType {
-			return meta.PackagePath + "." + fieldType
+	for _, packagePath := range searchPackages {
+		if this.findStruct(packagePath, fieldType) != nil {
+			return this.uniqueTypeName(packagePath, fieldType)
 		}
 	}
 
-	// 处理type TypeString string 这个情况。TypeString不作为节点存在
-	for _, meta := range this.typeAliasMetas {
-		if sliceContains(searchPackages, meta.PackagePath) && meta.Name == fieldType {
-			return meta.PackagePath + "." + fieldType
+	// 处理type ID = string 这种指向基本类型或复合类型的别名, 别名不作为节点存在
+	for _, packagePath := range searchPackages {
+		if this.findTypeAlias(packagePath, fieldType) != nil {
+			return this.uniqueTypeName(packagePath, fieldType)
 		}
 	}
 
@@ -1062,7 +1535,7 @@ func (this *analysisTool) findAliasByPackagePath(packagePath string) string {
 	return result
 }
 
-func (this *analysisTool) existStructOrInterfaceInPackage(typeName string, packageName string) bool {
+func (this *fileContext) existStructOrInterfaceInPackage(typeName string, packageName string) bool {
 	structMeta1 := this.findStruct(this.currentPackagePath, typeName)
 	if structMeta1 != nil {
 		return true
@@ -1071,7 +1544,7 @@ func (this *analysisTool) existStructOrInterfaceInPackage(typeName string, packa
 	return false
 }
 
-func (this *analysisTool) existTypeAliasInPackage(typeName string, packageName string) bool {
+func (this *fileContext) existTypeAliasInPackage(typeName string, packageName string) bool {
 	meta1 := this.findTypeAlias(this.currentPackagePath, typeName)
 	if meta1 != nil {
 		return true
@@ -1080,7 +1553,8 @@ func (this *analysisTool) existTypeAliasInPackage(typeName string, packageName s
 	return false
 }
 
-func (this *analysisTool) findPackagePathByAlias(alias string, structName string) string {
+// 找不到时在pos的位置记录诊断信息
+func (this *fileContext) findPackagePathByAlias(alias string, structName string, pos token.Pos) string {
 
 	if alias == "" {
 
@@ -1120,7 +1594,7 @@ func (this *analysisTool) findPackagePathByAlias(alias string, structName string
 		}
 
 		currentFileImportsjson, _ := json.Marshal(this.currentFileImports)
-		log.Warnf("找不到包的全路径，包名为%s，type name=%s, 在%s文件, matchedImportMetas=%d, currentFileImports=%s", alias, structName, this.currentFile, len(matchedImportMetas), currentFileImportsjson)
+		this.addDiagnostic(UnresolvedTypeDiagnostic, WarningSeverity, pos, "找不到包的全路径，包名为%s，type name=%s, matchedImportMetas=%d, currentFileImports=%s", alias, structName, len(matchedImportMetas), currentFileImportsjson)
 
 		return alias
 
@@ -1165,7 +1639,7 @@ func (this *analysisTool) findPackagePathByAlias(alias string, structName string
 		}
 
 		currentFileImportsjson, _ := json.Marshal(this.currentFileImports)
-		log.Warnf("找不到包的全路径，包名为%s，type name=%s, 在%s文件, matchedImportMetas=%d, currentFileImports=%s", alias, structName, this.currentFile, len(matchedImportMetas), currentFileImportsjson)
+		this.addDiagnostic(UnresolvedTypeDiagnostic, WarningSeverity, pos, "找不到包的全路径，包名为%s，type name=%s, matchedImportMetas=%d, currentFileImports=%s", alias, structName, len(matchedImportMetas), currentFileImportsjson)
 
 		return alias
 
@@ -1173,7 +1647,7 @@ func (this *analysisTool) findPackagePathByAlias(alias string, structName string
 
 }
 
-func (this *analysisTool) interfaceBodyToString(interfaceType *ast.InterfaceType) string {
+func (this *fileContext) interfaceBodyToString(interfaceType *ast.InterfaceType) string {
 
 	result := " {\n"
 
@@ -1183,6 +1657,9 @@ func (this *analysisTool) interfaceBodyToString(interfaceType *ast.InterfaceType
 
 		if ok {
 			result += "  " + this.IdentsToString(field.Names) + this.funcParamsResultsToString(funcType) + "\n"
+		} else if len(field.Names) == 0 {
+			// 嵌入的接口或者类型元素
+			result += "  " + this.typeToString(field.Type, false) + "\n"
 		}
 
 	}
@@ -1193,14 +1670,9 @@ func (this *analysisTool) interfaceBodyToString(interfaceType *ast.InterfaceType
 
 }
 
-func (this *analysisTool) content(t ast.Expr) string {
-	bytes, err := ioutil.ReadFile(this.currentFile)
-	if err != nil {
-		log.Error("读取文件", this.currentFile, "失败", err)
-		return ""
-	}
-
-	return string(bytes[t.Pos()-1 : t.End()-1])
+// 使用缓存时表达式不是从原文件解析的, 所以不从原文件截取
+func (this *fileContext) content(t ast.Expr) string {
+	return types.ExprString(t)
 }
 
 /**
@@ -1210,7 +1682,7 @@ func (this *analysisTool) findInterfaceImpls(interfaceMeta1 *structMeta) []*stru
 	metas := []*structMeta{}
 
 	for _, structMeta1 := range this.structMetas {
-		if structMeta1.category != StructCategory {
+		if !structMeta1.isConcrete() {
 			continue
 		}
 		if this.inheritance(interfaceMeta1, structMeta1) {
@@ -1223,16 +1695,16 @@ func (this *analysisTool) findInterfaceImpls(interfaceMeta1 *structMeta) []*stru
 
 func (this *analysisTool) UML() string {
 
-	uml := ""
+	var uml strings.Builder
 
 	for _, structMeta1 := range this.structMetas {
-		uml += structMeta1.UML
-		uml += "\n"
+		uml.WriteString(structMeta1.UML)
+		uml.WriteString("\n")
 	}
 
 	for _, d := range this.dependencyRelations {
-		uml += d.uml
-		uml += "\n"
+		uml.WriteString(d.uml)
+		uml.WriteString("\n")
 	}
 
 	for _, interfaceMeta1 := range this.structMetas {
@@ -1242,14 +1714,16 @@ func (this *analysisTool) UML() string {
 
 		structMetas := this.findInterfaceImpls(interfaceMeta1)
 		for _, structMeta := range structMetas {
-			uml += structMeta.implInterfaceUML(interfaceMeta1)
+			if !this.embeds(structMeta, interfaceMeta1) {
+				uml.WriteString(this.implInterfaceUML(interfaceMeta1, structMeta))
+			}
 		}
 	}
 
-	return "@startuml\n" + uml + "@enduml"
+	return "@startuml\n" + uml.String() + "@enduml"
 }
 
-func (this *analysisTool) OutputToFile(logdir string, nodename string, nodedepth uint16, showtest bool) {
+func (this *analysisTool) OutputToFile(logdir string, nodename string, nodedepth uint16, showtest bool) error {
 	var uml string
 	var logfile string
 
@@ -1261,20 +1735,31 @@ func (this *analysisTool) OutputToFile(logdir string, nodename string, nodedepth
 		uml = this.UML()
 		logfile = logdir + "/all.puml"
 	} else {
-		uml = this.filterUML(nodename, nodedepth, showtest)
+		filtered, err := this.filterUML(nodename, nodedepth, showtest)
+		if err != nil {
+			return err
+		}
+		uml = filtered
 		logfile += fmt.Sprintf("%s/node-%s-%d-%v.puml", logdir, nodename, nodedepth, showtest)
 	}
-	ioutil.WriteFile(logfile, []byte(uml), 0666)
-	log.Infof("数据已保存到%s\n", logfile)
 
+	if old, err := ioutil.ReadFile(logfile); err == nil && string(old) == uml {
+		log.Infof("%s内容没有变化\n", logfile)
+		return nil
+	}
+
+	if err := ioutil.WriteFile(logfile, []byte(uml), 0666); err != nil {
+		return err
+	}
+	log.Infof("数据已保存到%s\n", logfile)
+	return nil
 }
 
 func (tool *analysisTool) getMyParents(meta *structMeta) []*structMeta {
-	parents := make([]*structMeta, 0, 5)
-	for _, v := range tool.dependencyRelations {
-		if v.inheritance && v.source == meta {
-			parents = append(parents, v.target)
-		}
+	relations := tool.outgoingRelations(meta, InheritanceRelation)
+	parents := make([]*structMeta, 0, len(relations))
+	for _, v := range relations {
+		parents = append(parents, v.target)
 	}
 	return parents
 }
@@ -0,0 +1,118 @@
+package codeanalysis
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// 构造函数可以和类型定义在不同的文件, 合并时再加到类型上
+type pendingConstructor struct {
+	meta *structMeta
+	name string
+	sign string
+}
+
+// 导出的包级别函数, 第一个返回值是本包定义的类型, 后面最多还有一个error时作为这个类型的构造函数,
+// 例如 NewFabricSDK(opts ...Option) (*FabricSDK, error). 参数的类型作为"created with"依赖.
+// 返回构造的类型, 不是构造函数时返回nil
+func (this *fileContext) visitConstructor(funcDecl *ast.FuncDecl) *structMeta {
+
+	// 未导出的函数多是辅助函数, 例如 isRelation(...) (bool, *DependencyRelation)
+	if !funcDecl.Name.IsExported() {
+		return nil
+	}
+
+	this.typeParams = typeParamNames(funcDecl.Type.TypeParams)
+
+	meta := this.constructedType(funcDecl.Type)
+	if meta == nil || (this.file.isTest && !meta.isTest) {
+		return nil
+	}
+
+	this.newConstructors = append(this.newConstructors, &pendingConstructor{
+		meta: meta,
+		name: funcDecl.Name.Name,
+		sign: "{static} " + funcDecl.Name.Name + this.funcParamsResultsToString(funcDecl.Type),
+	})
+
+	if funcDecl.Type.Params == nil {
+		return meta
+	}
+
+	for _, field := range funcDecl.Type.Params.List {
+
+		paramMeta1 := this.paramTypeMeta(field.Type)
+		if paramMeta1 == nil || paramMeta1 == meta || this.hasNewRelation(meta, paramMeta1, CreateRelation) {
+			continue
+		}
+
+		this.newRelations = append(this.newRelations, &DependencyRelation{
+			source: meta,
+			target: paramMeta1,
+			uml:    meta.UniqueNameUML() + " ..> " + paramMeta1.UniqueNameUML() + " : created with",
+			kind:   CreateRelation,
+		})
+	}
+
+	return meta
+}
+
+// 函数返回的本包定义的类型, 只有 T, *T, (T, error), (*T, error) 这几种形式算构造函数
+func (this *fileContext) constructedType(funcType *ast.FuncType) *structMeta {
+
+	if funcType.Results == nil {
+		return nil
+	}
+
+	results := []ast.Expr{}
+	for _, field := range funcType.Results.List {
+		results = append(results, field.Type)
+		for i := 1; i < len(field.Names); i++ {
+			results = append(results, field.Type)
+		}
+	}
+
+	if len(results) > 2 {
+		return nil
+	}
+	if len(results) == 2 {
+		ident, ok := results[1].(*ast.Ident)
+		if !ok || ident.Name != "error" {
+			return nil
+		}
+	}
+
+	t := results[0]
+	starExpr, ok := t.(*ast.StarExpr)
+	if ok {
+		t = starExpr.X
+	}
+	t, _ = splitTypeArguments(t)
+
+	ident, ok := t.(*ast.Ident)
+	if !ok || sliceContains(this.typeParams, ident.Name) {
+		return nil
+	}
+
+	return this.findStruct(this.currentPackagePath, ident.Name)
+}
+
+// 所有文件都解析完以后, 构造函数作为静态方法加到类型的UML中
+func (this *analysisTool) renderConstructors() {
+	for _, meta := range this.structMetas {
+		if len(meta.constructors) > 0 {
+			meta.UML = addMembersToUML(meta.UML, meta.constructors)
+		}
+	}
+}
+
+// 在类型的右括号前加入成员, UML的格式为 namespace xxx {\n class Name {\n ...\n} \n}
+func addMembersToUML(uml string, members []string) string {
+
+	index := strings.LastIndex(uml, "} \n}")
+	if index < 0 {
+		return uml
+	}
+
+	return uml[:index] + "  " + strings.Join(members, "\n  ") + "\n" + uml[index:]
+}
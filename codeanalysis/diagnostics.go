@@ -0,0 +1,176 @@
+package codeanalysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	ErrorSeverity   = "error"
+	WarningSeverity = "warning"
+)
+
+const (
+	// go文件解析失败
+	ParseErrorDiagnostic = "parse-error"
+	// 无法确认go文件所在的包路径
+	UnknownPackageDiagnostic = "unknown-package"
+	// 找不到类型所在包的全路径
+	UnresolvedTypeDiagnostic = "unresolved-type"
+	// typeToString等不支持的表达式
+	UnsupportedExprDiagnostic = "unsupported-expr"
+	// var _ I = (*T)(nil) 断言的实现关系无法确认
+	UnconfirmedAssertionDiagnostic = "unconfirmed-assertion"
+	// 推断出的实现关系没有对应的断言, 只在CheckAssertions时记录
+	MissingAssertionDiagnostic = "missing-assertion"
+)
+
+// 解析过程中发现的问题, 不会中断解析, 最后统一输出报告
+type Diagnostic struct {
+	Kind     string `json:"kind"`
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (this *Diagnostic) String() string {
+	location := this.File
+	if this.Line > 0 {
+		location = fmt.Sprintf("%s:%d", this.File, this.Line)
+	}
+	return fmt.Sprintf("%s: %s %s: %s", location, this.Severity, this.Kind, this.Message)
+}
+
+// 记录当前文件中的问题, pos无效时只记录文件
+func (this *fileContext) addDiagnostic(kind string, severity string, pos token.Pos, format string, args ...interface{}) {
+
+	d := &Diagnostic{
+		Kind:     kind,
+		Severity: severity,
+		File:     this.currentFile,
+		Message:  fmt.Sprintf(format, args...),
+	}
+
+	if pos.IsValid() {
+		d.Line = this.fset.Position(pos).Line
+	}
+
+	logDiagnostic(d)
+	this.fileDiagnostics = append(this.fileDiagnostics, d)
+}
+
+// 所有文件合并后发现的问题, 直接记录到analysisTool
+func (this *analysisTool) addToolDiagnostic(kind string, severity string, file string, line int, format string, args ...interface{}) {
+
+	d := &Diagnostic{
+		Kind:     kind,
+		Severity: severity,
+		File:     file,
+		Line:     line,
+		Message:  fmt.Sprintf(format, args...),
+	}
+
+	logDiagnostic(d)
+	this.diagnostics = append(this.diagnostics, d)
+}
+
+func logDiagnostic(d *Diagnostic) {
+	if d.Severity == ErrorSeverity {
+		log.Error(d.String())
+	} else {
+		log.Warn(d.String())
+	}
+}
+
+// 记录解析错误, 每个语法错误一条
+func (this *fileContext) addParseDiagnostics(err error) {
+
+	errorList, ok := err.(scanner.ErrorList)
+	if !ok {
+		this.addDiagnostic(ParseErrorDiagnostic, ErrorSeverity, token.NoPos, "解析失败, %s", err)
+		return
+	}
+
+	for _, e := range errorList {
+		d := &Diagnostic{
+			Kind:     ParseErrorDiagnostic,
+			Severity: ErrorSeverity,
+			File:     this.currentFile,
+			Line:     e.Pos.Line,
+			Message:  e.Msg,
+		}
+		log.Error(d.String())
+		this.fileDiagnostics = append(this.fileDiagnostics, d)
+	}
+}
+
+// 同一个类型在字段, 参数, 返回值等多处解析时会重复记录, 相同的诊断信息只保留第一条
+func (this *analysisTool) dedupeDiagnostics() {
+
+	type diagnosticKey struct {
+		file    string
+		line    int
+		kind    string
+		message string
+	}
+
+	seen := map[diagnosticKey]bool{}
+	diagnostics := this.diagnostics[:0]
+	for _, d := range this.diagnostics {
+		key := diagnosticKey{file: d.File, line: d.Line, kind: d.Kind, message: d.Message}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		diagnostics = append(diagnostics, d)
+	}
+	this.diagnostics = diagnostics
+}
+
+func (this *analysisTool) Diagnostics() []*Diagnostic {
+	return this.diagnostics
+}
+
+// 把诊断信息保存到文件, format为text或者json
+func (this *analysisTool) WriteDiagnostics(filename string, format string) error {
+
+	var content []byte
+
+	switch format {
+	case "json":
+		diagnostics := this.diagnostics
+		if diagnostics == nil {
+			diagnostics = []*Diagnostic{}
+		}
+
+		bytes, err := json.MarshalIndent(diagnostics, "", "  ")
+		if err != nil {
+			return err
+		}
+		content = append(bytes, '\n')
+
+	case "", "text":
+		lines := make([]string, 0, len(this.diagnostics))
+		for _, d := range this.diagnostics {
+			lines = append(lines, d.String()+"\n")
+		}
+		content = []byte(strings.Join(lines, ""))
+
+	default:
+		return fmt.Errorf("不支持的报告格式%s", format)
+	}
+
+	if err := ioutil.WriteFile(filename, content, 0666); err != nil {
+		return err
+	}
+
+	log.Infof("%d条诊断信息已保存到%s\n", len(this.diagnostics), filename)
+	return nil
+}
@@ -0,0 +1,66 @@
+package codeanalysis
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// 记录以本包定义的类型声明的常量, 例如 const ( Idle State = iota; Running ).
+// 没有写类型和值的常量沿用上一个常量的类型
+func (this *fileContext) visitConstDecl(genDecl *ast.GenDecl) {
+
+	var constType ast.Expr
+
+	for _, spec := range genDecl.Specs {
+
+		valueSpec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+
+		if valueSpec.Type != nil {
+			constType = valueSpec.Type
+		} else if len(valueSpec.Values) > 0 {
+			// 没有类型的常量, 或者 Paused = State(3) 这样的类型转换
+			constType = nil
+			call, ok := valueSpec.Values[0].(*ast.CallExpr)
+			if ok && len(call.Args) == 1 {
+				constType = call.Fun
+			}
+		}
+
+		ident, ok := constType.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		meta := this.findStruct(this.currentPackagePath, ident.Name)
+		if meta == nil || meta.category != DefinedCategory {
+			continue
+		}
+
+		for _, name := range valueSpec.Names {
+			if name.Name != "_" {
+				this.newEnumConsts = append(this.newEnumConsts, &pendingEnumConst{
+					meta: meta,
+					name: name.Name,
+				})
+			}
+		}
+	}
+}
+
+// 所有文件都解析完以后, 有常量的类型改为显示成enum
+func (this *analysisTool) renderEnums() {
+	for _, meta := range this.structMetas {
+		if len(meta.enumConsts) > 0 {
+			meta.UML = this.enumToUML(meta)
+		}
+	}
+}
+
+func (this *analysisTool) enumToUML(me *structMeta) string {
+	enumUML := "enum " + me.Name + me.StereotypeUML() + me.ColorfulUML() + " {\n  " + strings.Join(me.enumConsts, "\n  ") + "\n}"
+	return fmt.Sprintf("namespace %s {\n %s \n}", this.packagePathToUML(me.PackagePath), enumUML)
+}
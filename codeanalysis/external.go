@@ -0,0 +1,92 @@
+package codeanalysis
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+)
+
+// 代码目录以外的类型, 例如 sync.Mutex, 只在ShowExternal时显示为没有内容的节点.
+// 第二遍解析时并发创建, 同一个类型只创建一个节点
+func (this *analysisTool) externalStub(packagePath string, name string) *structMeta {
+
+	if !this.config.ShowExternal || packagePath == "" {
+		return nil
+	}
+
+	// 扫描过的包中找不到的类型不是外部类型
+	if this.scannedPackages[packagePath] {
+		return nil
+	}
+
+	key := typeKey{PackagePath: packagePath, Name: name}
+
+	this.externalMutex.Lock()
+	defer this.externalMutex.Unlock()
+
+	stub, ok := this.externals[key]
+	if !ok {
+		stub = &structMeta{
+			baseInfo: baseInfo{
+				PackagePath: packagePath,
+			},
+			Name:        name,
+			MethodSigns: []string{},
+			category:    ExternalCategory,
+		}
+		stub.UML = this.externalToUML(stub)
+		this.externals[key] = stub
+	}
+
+	return stub
+}
+
+// 通过类型检查的结果找到的外部类型
+func (this *analysisTool) externalStubByTypes(t ast.Expr) *structMeta {
+
+	typeName, ok := this.lookupTypeName(t)
+	if !ok || typeName.Pkg() == nil {
+		return nil
+	}
+
+	return this.externalStub(typeName.Pkg().Path(), typeName.Name())
+}
+
+// import的包路径, 找不到时返回空, 不记录诊断信息
+func (this *fileContext) importPath(alias string) string {
+	for _, import1 := range this.currentFileImports {
+		if import1.Alias == alias || import1.Path == alias {
+			return import1.Path
+		}
+	}
+	return ""
+}
+
+// 所有文件解析完以后, 外部类型的节点按包路径和名字排序后加入, 输出顺序和调度无关.
+// 查找类型时就会创建节点, 例如断言中的接口, 没有依赖关系指向的节点不显示
+func (this *analysisTool) addExternalStubs() {
+
+	stubs := make([]*structMeta, 0, len(this.externals))
+	for _, stub := range this.externals {
+		if len(this.symbols.incoming[stub]) == 0 {
+			continue
+		}
+		stubs = append(stubs, stub)
+	}
+
+	sort.Slice(stubs, func(i, j int) bool {
+		if stubs[i].PackagePath != stubs[j].PackagePath {
+			return stubs[i].PackagePath < stubs[j].PackagePath
+		}
+		return stubs[i].Name < stubs[j].Name
+	})
+
+	for _, stub := range stubs {
+		this.addDisplayNode(stub)
+	}
+}
+
+func (this *analysisTool) externalToUML(me *structMeta) string {
+	classUML := "class " + me.Name + " <<external>>" + me.ColorfulUML()
+	return fmt.Sprintf("namespace %s {\n %s \n}", this.packagePathToUML(me.PackagePath), classUML)
+}
@@ -0,0 +1,505 @@
+package codeanalysis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// 缓存格式的版本, 解析逻辑变化导致提取的内容变化时需要修改, 旧的缓存自动失效
+const factCacheVersion = "7"
+
+// 从一个go文件中提取的, 和其他文件无关的内容.
+// 类型表达式保存为源码片段, 使用时重新解析为表达式, 不需要解析整个文件
+type fileFacts struct {
+	PackageName string
+	Imports     []importFact
+	Types       []typeFact
+	Funcs       []funcFact
+	// 每个const声明中的常量
+	Consts [][]valueFact
+	// 每个var声明中的变量, 初始值只保存varValueSnippet的结果
+	Vars [][]valueFact
+}
+
+type importFact struct {
+	// import时指定的别名, 没有指定时为空
+	Name string
+	Path string
+}
+
+type typeFact struct {
+	Name string
+	// 类型表达式所在的行
+	Line int
+	Type string
+	// 是否是 type A = B 形式的别名
+	Alias bool
+	// 泛型类型的类型参数, 例如 [K comparable, V any]
+	TypeParams     string
+	TypeParamsLine int
+}
+
+// 常量/变量声明
+type valueFact struct {
+	Names []string
+	Line  int
+	// 显式写出的类型, 没有时为空
+	Type   string
+	Values []string
+}
+
+type funcFact struct {
+	Name string
+	Line int
+	// 接收者的类型, 普通函数为空
+	Recv     string
+	RecvLine int
+	RecvName string
+	// 函数类型, 例如 func(n int) error
+	Type string
+	// 泛型函数的类型参数, 例如 [K comparable, V any]
+	TypeParams     string
+	TypeParamsLine int
+	// 函数体中和类型有关的表达式, 例如 new(peer), r.peer.Send()
+	Body []exprFact
+}
+
+type exprFact struct {
+	Line int
+	Expr string
+}
+
+// 解析结果的缓存, 内存中保存一份, 设置了缓存目录时同时保存到磁盘.
+// 监视模式下多次解析共用一个factCache, 只有变化了的文件需要重新解析
+type factCache struct {
+	// 缓存目录, 为空时只缓存在内存中
+	dir string
+
+	mutex sync.Mutex
+	facts map[string]*fileFacts
+	// 本次解析用到的缓存, 解析完成后只保留这部分
+	used map[string]*fileFacts
+}
+
+func newFactCache(dir string) *factCache {
+	return &factCache{
+		dir:   dir,
+		facts: map[string]*fileFacts{},
+		used:  map[string]*fileFacts{},
+	}
+}
+
+// 文件内容对应的缓存key, 由缓存版本和文件内容的hash组成
+func (this *factCache) key(src []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(factCacheVersion + "\n"))
+	hash.Write(src)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (this *factCache) load(key string) (*fileFacts, bool) {
+
+	this.mutex.Lock()
+	facts, ok := this.facts[key]
+	this.mutex.Unlock()
+
+	if !ok {
+		facts, ok = this.loadFile(key)
+	}
+
+	if ok {
+		this.mutex.Lock()
+		this.facts[key] = facts
+		this.used[key] = facts
+		this.mutex.Unlock()
+	}
+
+	return facts, ok
+}
+
+func (this *factCache) save(key string, facts *fileFacts) {
+
+	this.mutex.Lock()
+	this.facts[key] = facts
+	this.used[key] = facts
+	this.mutex.Unlock()
+
+	this.saveFile(key, facts)
+}
+
+// 丢掉本次解析没有用到的缓存, 避免监视模式下内存一直增长
+func (this *factCache) prune() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.facts = this.used
+	this.used = map[string]*fileFacts{}
+}
+
+func (this *factCache) cacheFile(key string) string {
+	return path.Join(this.dir, key+".json")
+}
+
+func (this *factCache) loadFile(key string) (*fileFacts, bool) {
+
+	if this.dir == "" {
+		return nil, false
+	}
+
+	cacheFile := this.cacheFile(key)
+
+	bytes, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return nil, false
+	}
+
+	facts := &fileFacts{}
+	if err := json.Unmarshal(bytes, facts); err != nil {
+		log.Warnf("缓存文件%s格式错误, %s\n", cacheFile, err)
+		return nil, false
+	}
+
+	return facts, true
+}
+
+// 先写临时文件再改名, 多个进程共用缓存目录时不会读到写了一半的文件
+func (this *factCache) saveFile(key string, facts *fileFacts) {
+
+	if this.dir == "" {
+		return
+	}
+
+	cacheFile := this.cacheFile(key)
+
+	bytes, err := json.Marshal(facts)
+	if err != nil {
+		log.Errorf("保存缓存%s失败, %s\n", cacheFile, err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(this.dir, "facts")
+	if err != nil {
+		log.Errorf("保存缓存%s失败, %s\n", cacheFile, err)
+		return
+	}
+
+	_, err = tmp.Write(bytes)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), cacheFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Errorf("保存缓存%s失败, %s\n", cacheFile, err)
+	}
+}
+
+// 从完整解析的文件中提取需要缓存的内容
+func (this *analysisTool) extractFacts(src []byte, file *ast.File) *fileFacts {
+
+	snippet := func(node ast.Node) string {
+		return string(src[this.fset.Position(node.Pos()).Offset:this.fset.Position(node.End()).Offset])
+	}
+	line := func(node ast.Node) int {
+		return this.fset.Position(node.Pos()).Line
+	}
+
+	facts := &fileFacts{
+		PackageName: file.Name.Name,
+		Imports:     []importFact{},
+		Types:       []typeFact{},
+		Funcs:       []funcFact{},
+		Consts:      [][]valueFact{},
+		Vars:        [][]valueFact{},
+	}
+
+	for _, import1 := range file.Imports {
+		fact := importFact{}
+		if import1.Name != nil {
+			fact.Name = import1.Name.Name
+		}
+		fact.Path, _ = strconv.Unquote(import1.Path.Value)
+		facts.Imports = append(facts.Imports, fact)
+	}
+
+	for _, decl := range file.Decls {
+
+		genDecl, ok := decl.(*ast.GenDecl)
+		if ok && (genDecl.Tok == token.CONST || genDecl.Tok == token.VAR) {
+			values := []valueFact{}
+			for _, spec := range genDecl.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+				fact := valueFact{
+					Line:   line(valueSpec),
+					Values: []string{},
+				}
+				for _, name := range valueSpec.Names {
+					fact.Names = append(fact.Names, name.Name)
+				}
+				if valueSpec.Type != nil {
+					fact.Type = snippet(valueSpec.Type)
+				}
+				for _, value := range valueSpec.Values {
+					if genDecl.Tok == token.VAR {
+						fact.Values = append(fact.Values, varValueSnippet(value, snippet))
+					} else {
+						fact.Values = append(fact.Values, snippet(value))
+					}
+				}
+				values = append(values, fact)
+			}
+			if genDecl.Tok == token.VAR {
+				facts.Vars = append(facts.Vars, values)
+			} else {
+				facts.Consts = append(facts.Consts, values)
+			}
+		}
+
+		if ok {
+			for _, spec := range genDecl.Specs {
+				typeSpec, ok := spec.(*ast.TypeSpec)
+				if ok {
+					fact := typeFact{
+						Name:  typeSpec.Name.Name,
+						Line:  line(typeSpec.Type),
+						Type:  snippet(typeSpec.Type),
+						Alias: typeSpec.Assign.IsValid(),
+					}
+					if typeSpec.TypeParams != nil {
+						fact.TypeParams = snippet(typeSpec.TypeParams)
+						fact.TypeParamsLine = line(typeSpec.TypeParams)
+					}
+					facts.Types = append(facts.Types, fact)
+				}
+			}
+		}
+
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if ok {
+			fact := funcFact{
+				Name: funcDecl.Name.Name,
+				Line: line(funcDecl.Type.Params),
+				// 去掉函数名, 只保留参数和返回值
+				Type: "func" + string(src[this.fset.Position(funcDecl.Type.Params.Pos()).Offset:this.fset.Position(funcDecl.Type.End()).Offset]),
+			}
+			if funcDecl.Type.TypeParams != nil {
+				fact.TypeParams = snippet(funcDecl.Type.TypeParams)
+				fact.TypeParamsLine = line(funcDecl.Type.TypeParams)
+			}
+			if funcDecl.Recv != nil && len(funcDecl.Recv.List) > 0 {
+				fact.Recv = snippet(funcDecl.Recv.List[0].Type)
+				fact.RecvLine = line(funcDecl.Recv.List[0].Type)
+				fact.RecvName = receiverName(funcDecl)
+			}
+			fact.Body = this.extractBodyFacts(funcDecl, snippet)
+			facts.Funcs = append(facts.Funcs, fact)
+		}
+	}
+
+	return facts
+}
+
+// 用缓存的内容重建只包含声明的ast.File, 诊断信息中的行号和原文件一致
+func (this *analysisTool) factsToFile(filename string, facts *fileFacts) (*ast.File, error) {
+
+	file := &ast.File{
+		Name: ast.NewIdent(facts.PackageName),
+	}
+
+	if len(facts.Imports) > 0 {
+		importDecl := &ast.GenDecl{Tok: token.IMPORT}
+		for _, fact := range facts.Imports {
+			spec := &ast.ImportSpec{
+				Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(fact.Path)},
+			}
+			if fact.Name != "" {
+				spec.Name = ast.NewIdent(fact.Name)
+			}
+			file.Imports = append(file.Imports, spec)
+			importDecl.Specs = append(importDecl.Specs, spec)
+		}
+		file.Decls = append(file.Decls, importDecl)
+	}
+
+	for _, fact := range facts.Types {
+		t, err := this.parseFactExpr(filename, fact.Line, fact.Type)
+		if err != nil {
+			return nil, err
+		}
+		typeSpec := &ast.TypeSpec{
+			Name: ast.NewIdent(fact.Name),
+			Type: t,
+		}
+		if fact.Alias {
+			// 只用来区分是不是别名, 位置没有意义
+			typeSpec.Assign = t.Pos()
+		}
+		if fact.TypeParams != "" {
+			typeSpec.TypeParams, err = this.parseFactTypeParams(filename, fact.TypeParamsLine, fact.TypeParams)
+			if err != nil {
+				return nil, err
+			}
+		}
+		file.Decls = append(file.Decls, &ast.GenDecl{
+			Tok:   token.TYPE,
+			Specs: []ast.Spec{typeSpec},
+		})
+	}
+
+	for _, consts := range facts.Consts {
+		constDecl, err := this.factsToValueDecl(filename, token.CONST, consts)
+		if err != nil {
+			return nil, err
+		}
+		file.Decls = append(file.Decls, constDecl)
+	}
+
+	for _, vars := range facts.Vars {
+		varDecl, err := this.factsToValueDecl(filename, token.VAR, vars)
+		if err != nil {
+			return nil, err
+		}
+		file.Decls = append(file.Decls, varDecl)
+	}
+
+	for _, fact := range facts.Funcs {
+		t, err := this.parseFactExpr(filename, fact.Line, fact.Type)
+		if err != nil {
+			return nil, err
+		}
+		funcType, ok := t.(*ast.FuncType)
+		if !ok {
+			return nil, fmt.Errorf("%s不是函数类型", fact.Type)
+		}
+
+		if fact.TypeParams != "" {
+			funcType.TypeParams, err = this.parseFactTypeParams(filename, fact.TypeParamsLine, fact.TypeParams)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		funcDecl := &ast.FuncDecl{
+			Name: ast.NewIdent(fact.Name),
+			Type: funcType,
+		}
+		if fact.Recv != "" {
+			recv, err := this.parseFactExpr(filename, fact.RecvLine, fact.Recv)
+			if err != nil {
+				return nil, err
+			}
+			funcDecl.Recv = &ast.FieldList{List: []*ast.Field{{Type: recv}}}
+			if fact.RecvName != "" {
+				funcDecl.Recv.List[0].Names = []*ast.Ident{ast.NewIdent(fact.RecvName)}
+			}
+		}
+		if len(fact.Body) > 0 {
+			funcDecl.Body = &ast.BlockStmt{}
+			for _, bodyFact := range fact.Body {
+				expr, err := this.parseFactExpr(filename, bodyFact.Line, bodyFact.Expr)
+				if err != nil {
+					return nil, err
+				}
+				funcDecl.Body.List = append(funcDecl.Body.List, &ast.ExprStmt{X: expr})
+			}
+		}
+		file.Decls = append(file.Decls, funcDecl)
+	}
+
+	return file, nil
+}
+
+// 函数体只保存bodyRefs用到的部分, 重建后bodyRefs的结果和原来一样:
+// 创建的类型保存为 new(T), 接收者字段的方法调用保存为 r.peer.Send()
+func (this *analysisTool) extractBodyFacts(funcDecl *ast.FuncDecl, snippet func(node ast.Node) string) []exprFact {
+
+	facts := []exprFact{}
+	recvName := receiverName(funcDecl)
+
+	for _, ref := range bodyRefs(funcDecl.Body) {
+
+		fact := exprFact{Line: this.fset.Position(ref.pos).Line}
+
+		if ref.kind == InstantiateRelation {
+			fact.Expr = "new(" + snippet(ref.expr) + ")"
+		} else if _, ok := receiverFieldOfCall(ref.expr.(*ast.SelectorExpr), recvName); ok {
+			fact.Expr = snippet(ref.expr) + "()"
+		} else {
+			continue
+		}
+
+		facts = append(facts, fact)
+	}
+
+	return facts
+}
+
+func (this *analysisTool) factsToValueDecl(filename string, tok token.Token, facts []valueFact) (*ast.GenDecl, error) {
+
+	genDecl := &ast.GenDecl{Tok: tok}
+
+	for _, fact := range facts {
+		valueSpec, err := this.factToValueSpec(filename, fact)
+		if err != nil {
+			return nil, err
+		}
+		genDecl.Specs = append(genDecl.Specs, valueSpec)
+	}
+
+	return genDecl, nil
+}
+
+func (this *analysisTool) factToValueSpec(filename string, fact valueFact) (*ast.ValueSpec, error) {
+
+	valueSpec := &ast.ValueSpec{}
+
+	for _, name := range fact.Names {
+		valueSpec.Names = append(valueSpec.Names, ast.NewIdent(name))
+	}
+
+	if fact.Type != "" {
+		t, err := this.parseFactExpr(filename, fact.Line, fact.Type)
+		if err != nil {
+			return nil, err
+		}
+		valueSpec.Type = t
+	}
+
+	for _, value := range fact.Values {
+		v, err := this.parseFactExpr(filename, fact.Line, value)
+		if err != nil {
+			return nil, err
+		}
+		valueSpec.Values = append(valueSpec.Values, v)
+	}
+
+	return valueSpec, nil
+}
+
+// 用//line指令让表达式的位置指向原文件中的行
+func (this *analysisTool) parseFactExpr(filename string, line int, expr string) (ast.Expr, error) {
+	src := fmt.Sprintf("//line %s:%d\n%s", filename, line, expr)
+	return parser.ParseExprFrom(this.fset, filename, src, 0)
+}
+
+// 类型参数不是表达式, 放到一个类型声明里解析
+func (this *analysisTool) parseFactTypeParams(filename string, line int, typeParams string) (*ast.FieldList, error) {
+	src := fmt.Sprintf("package p; type _ /*line %s:%d*/%s int", filename, line, typeParams)
+	file, err := parser.ParseFile(this.fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	return file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).TypeParams, nil
+}
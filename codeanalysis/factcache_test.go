@@ -0,0 +1,155 @@
+package codeanalysis
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var factCacheTestFiles = map[string]string{
+	"model/model.go": `package model
+
+import "fmt"
+
+type Stringer = fmt.Stringer
+
+type ID = string
+
+type List[T any] struct {
+	items []T
+}
+
+func (l *List[T]) Add(item T) {
+	l.items = append(l.items, item)
+}
+
+type Number interface {
+	~int | ~float64
+}
+
+func Sum[N Number](values ...N) N {
+	var total N
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+
+type User struct {
+	ID    ID
+	Color Color
+	Tags  map[string]*Tag
+}
+
+type Tag struct {
+	Name string
+}
+
+func (u User) String() string {
+	return string(u.ID)
+}
+
+func NewUser(id ID) *User {
+	return &User{ID: id}
+}
+`,
+	"store/store.go": `package store
+
+import (
+	"sync"
+
+	"example.com/app/model"
+)
+
+type Store interface {
+	Save(u *model.User) error
+	Load(id model.ID) (*model.User, error)
+}
+
+type Users = model.List[model.User]
+
+type memStore struct {
+	mu    sync.Mutex
+	users *Users
+	peer  *peer
+}
+
+type peer struct{}
+
+func (p *peer) Send(u *model.User) {}
+
+var _ Store = (*memStore)(nil)
+
+var defaultStore = newMemStore()
+
+var admin = model.NewUser("admin")
+
+func newMemStore() *memStore {
+	return &memStore{users: new(Users), peer: &peer{}}
+}
+
+func (s *memStore) Save(u *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users.Add(*u)
+	s.peer.Send(u)
+	return nil
+}
+
+func (s *memStore) Load(id model.ID) (*model.User, error) {
+	return &model.User{ID: id}, nil
+}
+
+func (s *memStore) Broken(x missing.Type) {}
+`,
+}
+
+// 不使用缓存, 使用空的缓存, 使用已有的缓存时, 解析结果都应该相同
+func TestFactCacheKeepsAnalysisResult(t *testing.T) {
+
+	dir := writeTestModule(t, factCacheTestFiles)
+	defer os.RemoveAll(dir)
+
+	cacheDir, err := ioutil.TempDir("", "factcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	config := Config{
+		CodeDir:         dir,
+		VendorDir:       filepath.Join(dir, "vendor"),
+		ShowAlias:       true,
+		ShowUses:        true,
+		CheckAssertions: true,
+		ShowExternal:    true,
+	}
+
+	uncached := analysisCode(config, nil)
+	cold := analysisCode(config, newFactCache(cacheDir))
+	// 新的factCache从磁盘读取缓存
+	warm := analysisCode(config, newFactCache(cacheDir))
+
+	if len(uncached.structMetas) == 0 || len(uncached.Diagnostics()) == 0 {
+		t.Fatalf("test module produced %d nodes and %d diagnostics", len(uncached.structMetas), len(uncached.Diagnostics()))
+	}
+
+	for name, tool := range map[string]*analysisTool{"empty cache": cold, "warm cache": warm} {
+		if uml := tool.UML(); uml != uncached.UML() {
+			t.Errorf("%s: UML differs\n--- uncached\n%s\n--- %s\n%s", name, uncached.UML(), name, uml)
+		}
+		if !reflect.DeepEqual(tool.Diagnostics(), uncached.Diagnostics()) {
+			t.Errorf("%s: diagnostics differ\n--- uncached\n%v\n--- %s\n%v", name, uncached.Diagnostics(), name, tool.Diagnostics())
+		}
+	}
+}
@@ -1,45 +1,49 @@
 package codeanalysis
 
 import (
-	"os"
+	"strings"
 
 	"fmt"
 
 	log "github.com/Sirupsen/logrus"
 )
 
-func (this *analysisTool) filterUML(nodename string, nodedepth uint16, showtest bool) string {
+// 找不到nodename时返回错误, 监视模式下nodename可能因为正在编辑暂时不存在
+func (this *analysisTool) filterUML(nodename string, nodedepth uint16, showtest bool) (string, error) {
 
-	uml := ""
+	var uml strings.Builder
 	var filteredStructMetas []*structMeta
-	var newRelations []*DependencyRelation
+	// filteredStructMetas, 以及本层新加入的节点
+	filteredKeys := map[typeKey]bool{}
+	newestKeys := map[typeKey]bool{}
+	// 已经输出过的实现关系
+	newRelations := map[[2]*structMeta]bool{}
 
-	for _, structMeta1 := range this.structMetas {
+	for _, structMeta1 := range this.symbols.structsByName[nodename] {
 		log.Infof("name: %s, package: %s", structMeta1.Name, structMeta1.baseInfo.PackagePath)
-		if structMeta1.Name == nodename {
-			if showtest || !structMeta1.isTest {
-				filteredStructMetas = append(filteredStructMetas, structMeta1)
-			}
+		if showtest || !structMeta1.isTest {
+			filteredStructMetas = append(filteredStructMetas, structMeta1)
+			filteredKeys[structMeta1.key()] = true
 		}
 	}
 
 	if len(filteredStructMetas) == 0 {
-		log.Infof("找不到struct/interface: %s\n", nodename)
-		os.Exit(-1)
+		return "", fmt.Errorf("找不到struct/interface: %s", nodename)
 	}
 
 	showDependencyRelations(this.dependencyRelations)
 
 	var addedStructMeta *structMeta
 	var filteredDependencyRelations []*DependencyRelation
-	var isNewRelation bool
+	filteredRelationSet := map[*DependencyRelation]bool{}
 
 	if len(filteredStructMetas) > 1 {
 		for i := 0; i < len(filteredStructMetas)-1; i++ {
 			for j := i + 1; j < len(filteredStructMetas); j++ {
 				// 如果存在的关系，则把关系放到 filteredDependencyRelations
-				if ok, r := isRelation(this.dependencyRelations, filteredStructMetas[i], filteredStructMetas[j]); ok {
+				if ok, r := this.isRelation(filteredStructMetas[i], filteredStructMetas[j]); ok && !filteredRelationSet[r] {
 					filteredDependencyRelations = append(filteredDependencyRelations, r)
+					filteredRelationSet[r] = true
 				}
 			}
 		}
@@ -47,14 +51,14 @@ func (this *analysisTool) filterUML(nodename string, nodedepth uint16, showtest
 
 	var layer uint16 = 1
 	for ; layer <= nodedepth; layer++ {
-		newestStructMetas := make([]*structMeta, 0, len(this.dependencyRelations))
+		newestStructMetas := make([]*structMeta, 0)
 		//从关系中找到下一层节点
-		for _, d := range this.dependencyRelations {
-			if exists := dependencyRelationExists(filteredDependencyRelations, d); exists {
+		for _, d := range this.relationsOfAll(filteredStructMetas) {
+			if filteredRelationSet[d] || (d.kind.inBody() && !this.config.FollowBody) {
 				continue
 			}
 
-			source, target := relationExists(filteredStructMetas, d)
+			source, target := filteredKeys[d.source.key()], filteredKeys[d.target.key()]
 			if source == target {
 				continue
 			}
@@ -65,21 +69,28 @@ func (this *analysisTool) filterUML(nodename string, nodedepth uint16, showtest
 				addedStructMeta = d.source
 			}
 
+			// 外部类型的节点不再向外展开
+			if (source && d.source.category == ExternalCategory) || (target && d.target.category == ExternalCategory) {
+				continue
+			}
+
 			if showtest || !addedStructMeta.isTest {
-				if exists := structExists(filteredStructMetas, newestStructMetas, addedStructMeta); !exists {
+				if !filteredKeys[addedStructMeta.key()] && !newestKeys[addedStructMeta.key()] {
 
 					addedStructMeta.Layer = layer
 					newestStructMetas = append(newestStructMetas, addedStructMeta)
+					newestKeys[addedStructMeta.key()] = true
 
 				}
 				filteredDependencyRelations = append(filteredDependencyRelations, d)
+				filteredRelationSet[d] = true
 			}
 		}
 
 		//从filteredStructMetas找没有扫描过的struct，进而找他们的实现的接口
 		for _, structMeta1 := range filteredStructMetas {
 
-			if structMeta1.category != StructCategory {
+			if !structMeta1.isConcrete() {
 				continue
 			}
 			if structMeta1.scaned {
@@ -97,14 +108,16 @@ func (this *analysisTool) filterUML(nodename string, nodedepth uint16, showtest
 
 				if showtest || !sm.isTest {
 					if this.inheritance(sm, structMeta1) {
-						if newRelations, isNewRelation = checkNewRelation(newRelations, sm, structMeta1); isNewRelation {
-							uml += structMeta1.implInterfaceUML(sm)
+						if pair := [2]*structMeta{sm, structMeta1}; !newRelations[pair] && !this.embeds(structMeta1, sm) {
+							newRelations[pair] = true
+							uml.WriteString(this.implInterfaceUML(sm, structMeta1))
 						}
 
-						if exists := structExists(filteredStructMetas, newestStructMetas, sm); !exists {
+						if !filteredKeys[sm.key()] && !newestKeys[sm.key()] {
 
 							sm.Layer = layer
 							newestStructMetas = append(newestStructMetas, sm)
+							newestKeys[sm.key()] = true
 						}
 					}
 				}
@@ -125,51 +138,70 @@ func (this *analysisTool) filterUML(nodename string, nodedepth uint16, showtest
 			impls := this.findInterfaceImpls(structMeta1)
 			for _, impl := range impls {
 				if showtest || !impl.isTest {
-					if newRelations, isNewRelation = checkNewRelation(newRelations, structMeta1, impl); isNewRelation {
-						uml += impl.implInterfaceUML(structMeta1)
+					if pair := [2]*structMeta{structMeta1, impl}; !newRelations[pair] && !this.embeds(impl, structMeta1) {
+						newRelations[pair] = true
+						uml.WriteString(this.implInterfaceUML(structMeta1, impl))
 					}
-					if exists := structExists(filteredStructMetas, newestStructMetas, impl); !exists {
+					if !filteredKeys[impl.key()] && !newestKeys[impl.key()] {
 
 						impl.Layer = layer
 						newestStructMetas = append(newestStructMetas, impl)
+						newestKeys[impl.key()] = true
 					}
 				}
 			}
 		}
 
 		filteredStructMetas = append(filteredStructMetas, newestStructMetas...)
+		for key := range newestKeys {
+			filteredKeys[key] = true
+		}
+		newestKeys = map[typeKey]bool{}
+	}
+
+	// 不沿着函数体中的依赖查找时, 只画已经显示的节点之间的这些依赖
+	if !this.config.FollowBody {
+		for _, d := range this.relationsOfAll(filteredStructMetas) {
+			if d.kind.inBody() && filteredKeys[d.source.key()] && filteredKeys[d.target.key()] {
+				filteredDependencyRelations = append(filteredDependencyRelations, d)
+			}
+		}
 	}
 
 	for _, structMeta1 := range filteredStructMetas {
-		uml += structMeta1.UML
-		uml += "\n"
-		uml += fmt.Sprintf("note top of %s: layer #%d%s \n", structMeta1.UniqueNameUML(), structMeta1.Layer, structMeta1.TextNote())
+		uml.WriteString(structMeta1.UML)
+		uml.WriteString("\n")
+		fmt.Fprintf(&uml, "note top of %s: layer #%d%s \n", structMeta1.UniqueNameUML(), structMeta1.Layer, structMeta1.TextNote())
 	}
 
 	for _, d := range filteredDependencyRelations {
-		uml += d.uml
-		uml += "\n"
+		uml.WriteString(d.uml)
+		uml.WriteString("\n")
 	}
 
-	return "@startuml\n" + uml + "@enduml"
+	return "@startuml\n" + uml.String() + "@enduml", nil
 }
 
-func checkNewRelation(relations []*DependencyRelation, source *structMeta, target *structMeta) ([]*DependencyRelation, bool) {
-	for _, v := range relations {
-		if v.source == source && v.target == target {
-			return relations, false
+// metas相关的所有依赖关系, 去重后按加入的先后排序
+func (this *analysisTool) relationsOfAll(metas []*structMeta) []*DependencyRelation {
+	relations := []*DependencyRelation{}
+	added := map[*DependencyRelation]bool{}
+
+	for _, meta := range metas {
+		for _, d := range this.relationsOf(meta) {
+			if !added[d] {
+				added[d] = true
+				relations = append(relations, d)
+			}
 		}
 	}
 
-	relations = append(relations, &DependencyRelation{
-		source: source,
-		target: target,
-	})
-	return relations, true
+	sortRelations(relations)
+	return relations
 }
 
-func isRelation(relations []*DependencyRelation, meta *structMeta, meta2 *structMeta) (bool, *DependencyRelation) {
-	for _, r := range relations {
+func (this *analysisTool) isRelation(meta *structMeta, meta2 *structMeta) (bool, *DependencyRelation) {
+	for _, r := range this.relationsOf(meta) {
 		if (isSame(r.source, meta) && isSame(r.target, meta2)) ||
 			(isSame(r.source, meta2) && isSame(r.target, meta)) {
 			return true, r
@@ -194,85 +226,150 @@ func showDependencyRelations(relations []*DependencyRelation) {
 
 }
 
-func structExists(metas2 []*structMeta, metas []*structMeta, meta *structMeta) bool {
-	for _, r := range metas {
-		if meta.Name == r.Name && meta.PackagePath == r.PackagePath {
-			return true
-		}
+// impl或者*impl实现了接口, 或者代码中断言了实现关系
+func (this *analysisTool) inheritance(definedInterface, impl *structMeta) bool {
+	implements, _ := this.implementsInterface(definedInterface, impl)
+	return implements || this.asserted(definedInterface, impl)
+}
+
+// impl或者*impl是否实现了接口, pointerOnly表示只有*impl实现了接口
+func (this *analysisTool) implementsInterface(definedInterface, impl *structMeta) (implements bool, pointerOnly bool) {
+	if implements, pointerOnly, ok := this.implementsByTypes(definedInterface, impl); ok {
+		return implements, pointerOnly
 	}
-	for _, r := range metas2 {
-		if meta.Name == r.Name && meta.PackagePath == r.PackagePath {
-			return true
-		}
+
+	// 接口的方法集包括嵌入的接口的方法, 嵌入了没有扫描到的接口时无法确定
+	signs := this.methodSets(definedInterface).pointer
+	if len(signs) < 1 || definedInterface.constraint || !this.methodSetKnown(definedInterface) {
+		return false, false
 	}
-	return false
+
+	implMethodSet := this.methodSets(impl)
+	if containsAllSigns(implMethodSet.value, signs) {
+		return true, false
+	}
+	if containsAllSigns(implMethodSet.pointer, signs) {
+		return true, true
+	}
+
+	return false, false
 }
 
-func relationExists(metas []*structMeta, relation *DependencyRelation) (source bool, target bool) {
-	for _, r := range metas {
-		if relation.source.Name == r.Name && relation.source.PackagePath == r.PackagePath {
-			source = true
+func containsAllSigns(signs map[string]bool, subset map[string]bool) bool {
+	for sign := range subset {
+		if !signs[sign] {
+			return false
 		}
+	}
+	return true
+}
 
-		if relation.target.Name == r.Name && relation.target.PackagePath == r.PackagePath {
-			target = true
-		}
+// 实现关系的UML, 只有指针类型实现了接口时在箭头上标出来
+func (this *analysisTool) implInterfaceUML(interfaceMeta1 *structMeta, impl *structMeta) string {
+	arrow := " <|.. "
+	if this.asserted(interfaceMeta1, impl) {
+		// 断言过的实现关系用粗线, 和推断出的区分开
+		arrow = " <|.[bold]. "
 	}
+	uml := interfaceMeta1.UniqueNameUML() + arrow + impl.UniqueNameUML()
+	if _, pointerOnly := this.implementsInterface(interfaceMeta1, impl); pointerOnly {
+		uml += " : *" + impl.Name
+	}
+	return uml + "\n"
+}
 
-	return
+// 按Go规范计算的方法集, 包括自己的方法, 以及嵌入的struct/interface的方法
+type methodSet struct {
+	// T的方法集
+	value map[string]bool
+	// *T的方法集, 包括T的方法集
+	pointer map[string]bool
 }
 
-func dependencyRelationExists(relations []*DependencyRelation, relation *DependencyRelation) bool {
-	for _, r := range relations {
-		if r == relation {
-			return true
-		}
+func (this *analysisTool) methodSets(meta *structMeta) *methodSet {
+
+	if set, ok := this.methodSetCache[meta]; ok {
+		return set
 	}
-	return false
-}
 
-func (this *analysisTool) inheritance(definedInterface, impl *structMeta) bool {
-	signs := definedInterface.MethodSigns
-	if len(signs) < 1 {
-		return false
+	set := &methodSet{
+		value:   map[string]bool{},
+		pointer: map[string]bool{},
 	}
+	// 先放进缓存, 嵌入自身的指针时不会无限递归
+	this.methodSetCache[meta] = set
 
-	checkedStruct := make([]*structMeta, 0, 10)
-	for _, sign := range signs {
-		if ok, _ := this.hasTheSign(impl, sign, checkedStruct); !ok {
-			return false
+	for _, sign := range meta.MethodSigns {
+		set.pointer[sign] = true
+		if !meta.pointerMethodSigns[sign] {
+			set.value[sign] = true
 		}
 	}
 
-	return true
-}
+	for _, d := range this.outgoingRelations(meta, InheritanceRelation) {
+		parentSet := this.methodSets(d.target)
+
+		// 嵌入T时, T的方法集只包括T的方法; 嵌入*T或者接口时包括所有方法
+		promoted := parentSet.value
+		if d.pointer || d.target.category == InterfaceCategory {
+			promoted = parentSet.pointer
+		}
 
-func (this *analysisTool) hasTheSign(meta *structMeta, s string, checkedStruct []*structMeta) (bool, []*structMeta) {
-	mySigns := meta.MethodSigns
-	if sliceContains(mySigns, s) {
-		return true, checkedStruct
+		for sign := range parentSet.pointer {
+			set.pointer[sign] = true
+		}
+		for sign := range promoted {
+			set.value[sign] = true
+		}
 	}
-	checkedStruct = append(checkedStruct, meta)
 
-	myParents := this.getMyParents(meta)
+	return set
+}
+
+// meta是否直接或者间接嵌入了ancestor, 这时已经有-|>, 不需要再画实现关系
+func (this *analysisTool) embeds(meta *structMeta, ancestor *structMeta) bool {
 
-	var ok, exists bool
-	for _, parent := range myParents {
+	checked := map[*structMeta]bool{}
+	metas := this.getMyParents(meta)
 
-		exists = false
-		for _, v := range checkedStruct {
-			if v == parent {
-				exists = true
-				break
-			}
+	for len(metas) > 0 {
+		parent := metas[len(metas)-1]
+		metas = metas[:len(metas)-1]
+
+		if parent == ancestor {
+			return true
+		}
+
+		if !checked[parent] {
+			checked[parent] = true
+			metas = append(metas, this.getMyParents(parent)...)
 		}
-		if exists {
-			continue
+	}
+
+	return false
+}
+
+// 方法集是否完整, 嵌入的接口中只要有一个没有扫描到就不完整
+func (this *analysisTool) methodSetKnown(meta *structMeta) bool {
+
+	checked := map[*structMeta]bool{}
+	metas := []*structMeta{meta}
+
+	for len(metas) > 0 {
+		meta := metas[len(metas)-1]
+		metas = metas[:len(metas)-1]
+
+		if meta.embedsUnknown {
+			return false
 		}
 
-		if ok, checkedStruct = this.hasTheSign(parent, s, checkedStruct); ok {
-			return true, checkedStruct
+		checked[meta] = true
+		for _, parent := range this.getMyParents(meta) {
+			if !checked[parent] {
+				metas = append(metas, parent)
+			}
 		}
 	}
-	return false, checkedStruct
+
+	return true
 }
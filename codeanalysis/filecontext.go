@@ -0,0 +1,169 @@
+package codeanalysis
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// 单个go文件的解析状态和解析结果, 不同文件的fileContext可以并发解析,
+// 解析结果按文件顺序合并到analysisTool, 保证输出顺序和调度无关
+type fileContext struct {
+	*analysisTool
+
+	file *parsedFile
+
+	// 当前解析的go文件, 例如/appdev/go-demo/src/git.oschina.net/jscode/list-interface/a.go
+	currentFile string
+	// 当前解析的go文件,所在包路径, 例如git.oschina.net/jscode/list-interface
+	currentPackagePath string
+	// 当前解析的go文件,引入的其他包
+	currentFileImports []*importMeta
+
+	// 本文件定义的struct/interface, 第二遍解析时只修改本文件定义的节点
+	fileStructs map[string]*structMeta
+	// 正在解析的泛型类型或者方法的类型参数, 这些名字不是类型
+	typeParams []string
+
+	// 以下是还没有合并到analysisTool的解析结果
+	packageName       string
+	newStructMetas    []*structMeta
+	newTypeAliasMetas []*typeAliasMeta
+	newAliasTargets   []*pendingAliasTarget
+	newRelations      []*DependencyRelation
+	newMethodSigns    []*pendingMethodSign
+	newEnumConsts     []*pendingEnumConst
+	newConstructors   []*pendingConstructor
+	newFieldCalls     []*pendingFieldCall
+	newVars           []*pendingVar
+	newAssertions     []*implAssertion
+	fileDiagnostics   []*Diagnostic
+}
+
+// 方法可以和struct定义在不同的文件, 合并时再加到struct上
+type pendingMethodSign struct {
+	meta *structMeta
+	sign string
+	// 接收者是否是指针
+	pointer bool
+}
+
+func (this *analysisTool) newFileContext(file *parsedFile) *fileContext {
+	log.Debug("path=", file.path)
+
+	return &fileContext{
+		analysisTool:       this,
+		file:               file,
+		currentFile:        file.path,
+		currentPackagePath: file.packagePath,
+		fileStructs:        map[string]*structMeta{},
+	}
+}
+
+// 用config.Jobs个goroutine并发执行work(0)...work(n-1)
+func (this *analysisTool) runJobs(n int, work func(i int)) {
+
+	jobs := this.config.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > n {
+		jobs = n
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				work(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+}
+
+// 常量和它的类型可以定义在不同的文件, 合并时再加到类型上
+type pendingEnumConst struct {
+	meta *structMeta
+	name string
+}
+
+// 合并第一遍解析的结果: 包名, struct/interface, 别名
+func (this *analysisTool) mergeTypes(ctx *fileContext) {
+
+	if ctx.packageName != "" {
+		// 外部测试包(package p_test)和包p在同一目录, 不能作为包p的包名
+		if !isExternalTestPackage(ctx.currentFile, ctx.packageName) {
+			this.mapPackagePath_PackageName(ctx.currentPackagePath, ctx.packageName)
+		}
+		this.scannedPackages[ctx.currentPackagePath] = true
+	}
+
+	for _, meta := range ctx.newStructMetas {
+		this.addStruct(meta)
+	}
+	for _, meta := range ctx.newTypeAliasMetas {
+		this.addTypeAlias(meta)
+	}
+
+	this.mergeDiagnostics(ctx)
+}
+
+func isExternalTestPackage(filepath string, packageName string) bool {
+	return strings.HasSuffix(filepath, "_test.go") && strings.HasSuffix(packageName, "_test")
+}
+
+// 合并第二遍解析的结果: 方法签名, 依赖关系, 常量, 构造函数, 方法调用, 变量, 断言
+func (this *analysisTool) mergeFuncs(ctx *fileContext) {
+
+	for _, m := range ctx.newMethodSigns {
+		m.meta.MethodSigns = append(m.meta.MethodSigns, m.sign)
+		if m.pointer {
+			if m.meta.pointerMethodSigns == nil {
+				m.meta.pointerMethodSigns = map[string]bool{}
+			}
+			m.meta.pointerMethodSigns[m.sign] = true
+		}
+	}
+	for _, d := range ctx.newRelations {
+		// 不同文件中的函数可能有相同的依赖, 合并出现的位置
+		if d.kind.deduplicated() {
+			if existing := this.findRelation(d.source, d.target, d.kind); existing != nil {
+				existing.positions = append(existing.positions, d.positions...)
+				continue
+			}
+		}
+		this.addDependencyRelation(d)
+	}
+	for _, c := range ctx.newEnumConsts {
+		c.meta.enumConsts = append(c.meta.enumConsts, c.name)
+	}
+	for _, c := range ctx.newConstructors {
+		c.meta.constructors = append(c.meta.constructors, c.sign)
+		this.symbols.constructors[typeKey{PackagePath: ctx.currentPackagePath, Name: c.name}] = c.meta
+	}
+	this.fieldCalls = append(this.fieldCalls, ctx.newFieldCalls...)
+	this.vars = append(this.vars, ctx.newVars...)
+	for _, a := range ctx.newAssertions {
+		this.addAssertion(a)
+	}
+
+	this.mergeDiagnostics(ctx)
+}
+
+func (this *analysisTool) mergeDiagnostics(ctx *fileContext) {
+	this.diagnostics = append(this.diagnostics, ctx.fileDiagnostics...)
+	ctx.fileDiagnostics = nil
+}
@@ -0,0 +1,97 @@
+package codeanalysis
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// 类型参数的名字, 例如 [K comparable, V any] 对应 K, V
+func typeParamNames(typeParams *ast.FieldList) []string {
+
+	names := []string{}
+
+	if typeParams == nil {
+		return names
+	}
+
+	for _, field := range typeParams.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+
+	return names
+}
+
+// 去掉泛型类型的实参, 例如 Cache[K, V] 返回 Cache 和 K, V
+func splitTypeArguments(t ast.Expr) (ast.Expr, []ast.Expr) {
+
+	indexExpr, ok := t.(*ast.IndexExpr)
+	if ok {
+		return indexExpr.X, []ast.Expr{indexExpr.Index}
+	}
+
+	indexListExpr, ok := t.(*ast.IndexListExpr)
+	if ok {
+		return indexListExpr.X, indexListExpr.Indices
+	}
+
+	return t, nil
+}
+
+// 类型表达式中出现的所有类型实参, 例如 []*List[Pair[K, User]] 返回 Pair[K, User], K, User
+func typeArguments(t ast.Expr) []ast.Expr {
+
+	args := []ast.Expr{}
+
+	ast.Inspect(t, func(node ast.Node) bool {
+		expr, ok := node.(ast.Expr)
+		if ok {
+			_, exprArgs := splitTypeArguments(expr)
+			args = append(args, exprArgs...)
+		}
+		return true
+	})
+
+	return args
+}
+
+// 接口中的类型元素, 例如 ~int | ~string, comparable, 有类型元素的接口只能用作类型约束
+func (this *analysisTool) isTypeElement(t ast.Expr) bool {
+
+	binaryExpr, ok := t.(*ast.BinaryExpr)
+	if ok {
+		return binaryExpr.Op == token.OR
+	}
+
+	unaryExpr, ok := t.(*ast.UnaryExpr)
+	if ok {
+		return unaryExpr.Op == token.TILDE
+	}
+
+	// comparable也是只能用在约束中的类型元素, error和any是普通的接口
+	ident, ok := t.(*ast.Ident)
+	if ok {
+		return ident.Name != "error" && ident.Name != "any" && this.isGoBaseType(ident.Name)
+	}
+
+	return false
+}
+
+func (this *analysisTool) isConstraintInterface(interfaceType *ast.InterfaceType) bool {
+	for _, field := range interfaceType.Methods.List {
+		if len(field.Names) == 0 && this.isTypeElement(field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// 泛型类型在UML中的名字后缀, 例如 <K, V>
+func (this *structMeta) TypeParamsUML() string {
+	if len(this.typeParams) == 0 {
+		return ""
+	}
+	return "<" + strings.Join(this.typeParams, ", ") + ">"
+}
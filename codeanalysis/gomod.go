@@ -0,0 +1,101 @@
+package codeanalysis
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type moduleMeta struct {
+	// go.mod所在目录, 例如 /appdev/go-demo/list-interface
+	Dir string
+	// 模块路径, 例如 git.oschina.net/jscode/list-interface
+	Path string
+}
+
+// 查找离目录dir最近的go.mod, 找不到返回nil
+func (this *analysisTool) findModule(dir string) *moduleMeta {
+
+	if module, ok := this.moduleCache[dir]; ok {
+		return module
+	}
+
+	var module *moduleMeta
+
+	gomod := path.Join(dir, "go.mod")
+	if PathExists(gomod) {
+		modulePath := parseModulePath(gomod)
+		if modulePath != "" {
+			module = &moduleMeta{
+				Dir:  dir,
+				Path: modulePath,
+			}
+		} else {
+			log.Errorf("go.mod文件%s中找不到module定义\n", gomod)
+		}
+	} else {
+		parent := path.Dir(dir)
+		if parent != dir {
+			module = this.findModule(parent)
+		}
+	}
+
+	this.moduleCache[dir] = module
+
+	return module
+}
+
+// 从go.mod文件中解析模块路径
+func parseModulePath(gomod string) string {
+
+	file, err := os.Open(gomod)
+	if err != nil {
+		log.Errorf("读取文件%s失败, %s", gomod, err)
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(stripModComment(scanner.Text()))
+
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+
+		modulePath := fields[1]
+		if unquoted, err := strconv.Unquote(modulePath); err == nil {
+			modulePath = unquoted
+		}
+
+		return modulePath
+	}
+
+	return ""
+}
+
+func stripModComment(line string) string {
+	if index := strings.Index(line, "//"); index >= 0 {
+		line = line[:index]
+	}
+	return strings.TrimSpace(line)
+}
+
+// 根据go.mod计算目录的包路径
+func (this *analysisTool) dirToModulePackagePath(dir string) string {
+
+	module := this.findModule(dir)
+	if module == nil {
+		return ""
+	}
+
+	if dir == module.Dir {
+		return module.Path
+	}
+
+	return module.Path + "/" + strings.TrimPrefix(dir, module.Dir+"/")
+}
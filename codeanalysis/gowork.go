@@ -0,0 +1,189 @@
+package codeanalysis
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type replaceMeta struct {
+	// 被替换的模块路径, 例如 github.com/hyperledger/fabric-sdk-go
+	ModulePath string
+	// 替换成的本地目录, 已经是绝对路径
+	Dir string
+}
+
+type modFileMeta struct {
+	// go.work中use的目录, 已经是绝对路径
+	Uses []string
+	// 指向本地目录的replace
+	Replaces []*replaceMeta
+}
+
+// 解析go.work或者go.mod, 只关心use和指向本地目录的replace
+func parseModFile(filename string) *modFileMeta {
+
+	meta := &modFileMeta{}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Errorf("读取文件%s失败, %s", filename, err)
+		return meta
+	}
+	defer file.Close()
+
+	dir := path.Dir(filename)
+	block := ""
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := stripModComment(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if block != "" {
+			if line == ")" {
+				block = ""
+				continue
+			}
+			meta.addDirective(dir, block, line)
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+
+		meta.addDirective(dir, fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0])))
+	}
+
+	return meta
+}
+
+func (this *modFileMeta) addDirective(dir string, verb string, args string) {
+
+	switch verb {
+	case "use":
+		this.Uses = append(this.Uses, resolveModDir(dir, unquoteModArg(args)))
+
+	case "replace":
+		arrow := strings.Index(args, "=>")
+		if arrow < 0 {
+			return
+		}
+
+		olds := strings.Fields(args[:arrow])
+		news := strings.Fields(args[arrow+2:])
+		if len(olds) == 0 || len(news) != 1 {
+			// 替换成另一个模块版本, 不是本地目录
+			return
+		}
+
+		target := unquoteModArg(news[0])
+		if !isLocalModDir(target) {
+			return
+		}
+
+		this.Replaces = append(this.Replaces, &replaceMeta{
+			ModulePath: unquoteModArg(olds[0]),
+			Dir:        resolveModDir(dir, target),
+		})
+	}
+}
+
+func unquoteModArg(arg string) string {
+	if unquoted, err := strconv.Unquote(arg); err == nil {
+		return unquoted
+	}
+	return arg
+}
+
+func isLocalModDir(target string) bool {
+	return strings.HasPrefix(target, "./") || strings.HasPrefix(target, "../") ||
+		target == "." || target == ".." || filepath.IsAbs(target)
+}
+
+func resolveModDir(dir string, target string) string {
+	if filepath.IsAbs(target) {
+		return path.Clean(target)
+	}
+	return path.Join(dir, target)
+}
+
+// 计算需要扫描的代码目录, 工作区模式下是go.work中的所有模块, 以及replace指向的本地目录
+func (this *analysisTool) initCodeDirs() {
+
+	if this.config.WorkspaceDir == "" {
+		this.codeDirs = []string{this.config.CodeDir}
+		return
+	}
+
+	work := parseModFile(path.Join(this.config.WorkspaceDir, "go.work"))
+
+	codeDirs := []string{}
+	replaces := work.Replaces
+
+	for _, dir := range work.Uses {
+		if !PathExists(path.Join(dir, "go.mod")) {
+			log.Errorf("go.work中的目录%s找不到go.mod\n", dir)
+			continue
+		}
+		if !sliceContains(codeDirs, dir) {
+			codeDirs = append(codeDirs, dir)
+		}
+		replaces = append(replaces, parseModFile(path.Join(dir, "go.mod")).Replaces...)
+	}
+
+	for _, replace := range replaces {
+		if sliceContains(codeDirs, replace.Dir) {
+			continue
+		}
+		if !PathExists(replace.Dir) {
+			log.Errorf("replace %s指向的目录%s不存在\n", replace.ModulePath, replace.Dir)
+			continue
+		}
+
+		// 通过replace引用的包, 包路径以replace左边的模块路径为准
+		this.moduleCache[replace.Dir] = &moduleMeta{
+			Dir:  replace.Dir,
+			Path: replace.ModulePath,
+		}
+		codeDirs = append(codeDirs, replace.Dir)
+	}
+
+	log.Infof("工作区%s包含模块目录%v\n", this.config.WorkspaceDir, codeDirs)
+
+	this.codeDirs = codeDirs
+}
+
+// 判断遍历代码目录root时, 是否需要跳过目录dir
+func (this *analysisTool) skipDir(root string, dir string, info os.FileInfo) bool {
+
+	if dir == root {
+		return false
+	}
+
+	if strings.HasPrefix(info.Name(), ".") || HasPrefixInSomeElement(dir, this.config.IgnoreDirs, this.config.VendorDir) {
+		return true
+	}
+
+	// 和go build一样, testdata和_开头的目录中的文件不参与编译
+	if info.Name() == "testdata" || strings.HasPrefix(info.Name(), "_") {
+		return true
+	}
+
+	if dir == path.Join(root, "vendor") {
+		return true
+	}
+
+	// 嵌套的模块单独扫描, 避免重复解析
+	return sliceContains(this.codeDirs, dir)
+}
@@ -0,0 +1,43 @@
+package codeanalysis
+
+import (
+	"go/ast"
+)
+
+// map的键显示为关联的限定符, 例如 Registry "[key: string]" ---> "*" Peer, 不是map时返回空
+func (this *fileContext) mapQualifierUML(t ast.Expr) string {
+
+	mapType, ok := t.(*ast.MapType)
+	if !ok {
+		return ""
+	}
+
+	return "\"[key: " + this.typeToString(mapType.Key, false) + "]\" "
+}
+
+// 键是已扫描的类型时, 另外依赖键的类型, 例如 map[PeerID]*Peer 也依赖PeerID
+func (this *fileContext) addMapKeyRelation(source *structMeta, t ast.Expr, fieldNames string, added map[*structMeta]bool) {
+
+	mapType, ok := t.(*ast.MapType)
+	if !ok {
+		return
+	}
+
+	keyStruct1, _ := this.analysisTypeForDependencyRelation(mapType.Key)
+	if keyStruct1 == nil || added[keyStruct1] {
+		return
+	}
+	added[keyStruct1] = true
+
+	uml := source.UniqueNameUML() + " ---> " + keyStruct1.UniqueNameUML() + " : "
+	if fieldNames != "" {
+		uml += fieldNames + " "
+	}
+	uml += "key"
+
+	this.newRelations = append(this.newRelations, &DependencyRelation{
+		source: source,
+		target: keyStruct1,
+		uml:    uml,
+	})
+}
@@ -1,5 +1,22 @@
 package codeanalysis
 
+import (
+	"bytes"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// 找不到本机的go命令和GOROOT时才使用的标准库列表
 var stdlibs = []string{
 	"archive/tar",
 	"archive/zip",
@@ -145,3 +162,132 @@ var stdlibs = []string{
 	"unicode/utf8",
 	"unsafe",
 }
+
+var (
+	discoveredStdlibs   map[string]string
+	discoverStdlibsOnce sync.Once
+)
+
+// 标准库的包路径和包名, 每个进程只查找一次, 监视模式下重新解析时不再运行go list.
+// 返回的map是共用的, 不能修改
+func discoverStdlibs() map[string]string {
+	discoverStdlibsOnce.Do(func() {
+		discoveredStdlibs = findStdlibs()
+	})
+	return discoveredStdlibs
+}
+
+// 依次使用 go list std, 遍历GOROOT/src, 内置的列表
+func findStdlibs() map[string]string {
+
+	if libs := listStdlibs(); len(libs) > 0 {
+		log.Infof("使用go list std找到%d个标准库\n", len(libs))
+		return libs
+	}
+
+	if libs := walkGorootStdlibs(build.Default.GOROOT); len(libs) > 0 {
+		log.Infof("在GOROOT %s中找到%d个标准库\n", build.Default.GOROOT, len(libs))
+		return libs
+	}
+
+	log.Warnf("找不到本机的标准库, 使用内置的标准库列表\n")
+	libs := map[string]string{}
+	for _, lib := range stdlibs {
+		libs[lib] = path.Base(lib)
+	}
+	return libs
+}
+
+func listStdlibs() map[string]string {
+
+	cmd := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}}", "std")
+	// 在GOPATH模式下运行, 不受当前目录go.mod的影响
+	cmd.Env = append(os.Environ(), "GO111MODULE=off")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		log.Debugf("go list std失败, %s, %s\n", err, stderr.String())
+		return nil
+	}
+
+	libs := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !isPublicStdlib(fields[0]) {
+			continue
+		}
+		libs[fields[0]] = fields[1]
+	}
+	return libs
+}
+
+// 没有go命令时, 从GOROOT/src下每个目录的第一个go文件读取包名
+func walkGorootStdlibs(goroot string) map[string]string {
+
+	if goroot == "" {
+		return nil
+	}
+
+	src := filepath.Join(goroot, "src")
+	if !PathExists(src) {
+		return nil
+	}
+
+	libs := map[string]string{}
+	fset := token.NewFileSet()
+
+	filepath.Walk(src, func(dir string, info os.FileInfo, err error) error {
+
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+
+		importPath, err := filepath.Rel(src, dir)
+		if err != nil || importPath == "." {
+			return nil
+		}
+		importPath = filepath.ToSlash(importPath)
+
+		base := path.Base(importPath)
+		if importPath == "cmd" || base == "testdata" || base == "vendor" || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") {
+			return filepath.SkipDir
+		}
+		if !isPublicStdlib(importPath) {
+			return nil
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil
+		}
+
+		for _, file := range files {
+			name := file.Name()
+			if file.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			astFile, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+			if err != nil || astFile.Name.Name == "main" || astFile.Name.Name == "documentation" {
+				continue
+			}
+			libs[importPath] = astFile.Name.Name
+			break
+		}
+
+		return nil
+	})
+
+	return libs
+}
+
+// 代码中可以import的标准库, 不包括internal和vendor下的包
+func isPublicStdlib(importPath string) bool {
+	for _, part := range strings.Split(importPath, "/") {
+		if part == "internal" || part == "vendor" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package codeanalysis
+
+import (
+	"sort"
+)
+
+type RelationKind int
+
+const (
+	FieldRelation       RelationKind = iota // struct字段
+	InheritanceRelation                     // 嵌入, 也就是继承
+	AliasRelation                           // 别名指向目标类型
+	CreateRelation                          // 构造函数的参数类型
+	UsesRelation                            // 方法的参数和返回值类型
+	InstantiateRelation                     // 函数体中创建的类型
+	CallRelation                            // 函数体中调用了方法的类型
+	VarRelation                             // 包级别变量的类型
+)
+
+// 函数体中的依赖, 不是类型声明的一部分
+func (this RelationKind) inBody() bool {
+	return this == InstantiateRelation || this == CallRelation
+}
+
+// 每对类型只保留一个的依赖
+func (this RelationKind) deduplicated() bool {
+	return this == CreateRelation || this == UsesRelation || this.inBody()
+}
+
+type typeKey struct {
+	PackagePath string
+	Name        string
+}
+
+func (this *structMeta) key() typeKey {
+	return typeKey{PackagePath: this.PackagePath, Name: this.Name}
+}
+
+// struct/interface及其依赖关系的索引, 避免每次查找都遍历列表
+type symbolTable struct {
+	structs    map[typeKey]*structMeta
+	typeAliass map[typeKey]*typeAliasMeta
+	// 同名的struct/interface, 按nodename查找时使用
+	structsByName map[string][]*structMeta
+	// 构造函数, 包路径+函数名到它构造的类型
+	constructors map[typeKey]*structMeta
+	// 实现关系的断言, 按(接口, 实现)查找
+	assertions map[[2]*structMeta][]*implAssertion
+	// 以struct为起点/终点的依赖关系, 再按关系种类区分
+	outgoing map[*structMeta]map[RelationKind][]*DependencyRelation
+	incoming map[*structMeta]map[RelationKind][]*DependencyRelation
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{
+		structs:       map[typeKey]*structMeta{},
+		typeAliass:    map[typeKey]*typeAliasMeta{},
+		structsByName: map[string][]*structMeta{},
+		constructors:  map[typeKey]*structMeta{},
+		assertions:    map[[2]*structMeta][]*implAssertion{},
+		outgoing:      map[*structMeta]map[RelationKind][]*DependencyRelation{},
+		incoming:      map[*structMeta]map[RelationKind][]*DependencyRelation{},
+	}
+}
+
+func (this *analysisTool) addStruct(meta *structMeta) {
+
+	this.structMetas = append(this.structMetas, meta)
+
+	// 和以前遍历列表一样, 重名时以先出现的为准
+	if _, ok := this.symbols.structs[meta.key()]; !ok {
+		this.symbols.structs[meta.key()] = meta
+	}
+	this.symbols.structsByName[meta.Name] = append(this.symbols.structsByName[meta.Name], meta)
+}
+
+// 别名, 变量等节点只用来显示, 不加到structs索引中, 按名字查找类型时不会找到它们
+func (this *analysisTool) addDisplayNode(meta *structMeta) {
+	this.structMetas = append(this.structMetas, meta)
+	this.symbols.structsByName[meta.Name] = append(this.symbols.structsByName[meta.Name], meta)
+}
+
+func (this *analysisTool) addTypeAlias(meta *typeAliasMeta) {
+
+	this.typeAliasMetas = append(this.typeAliasMetas, meta)
+
+	key := typeKey{PackagePath: meta.PackagePath, Name: meta.Name}
+	if _, ok := this.symbols.typeAliass[key]; !ok {
+		this.symbols.typeAliass[key] = meta
+	}
+}
+
+func (this *analysisTool) addDependencyRelation(d *DependencyRelation) {
+
+	d.index = len(this.dependencyRelations)
+	this.dependencyRelations = append(this.dependencyRelations, d)
+
+	addRelationIndex(this.symbols.outgoing, d.source, d)
+	addRelationIndex(this.symbols.incoming, d.target, d)
+}
+
+func addRelationIndex(index map[*structMeta]map[RelationKind][]*DependencyRelation, meta *structMeta, d *DependencyRelation) {
+	byKind, ok := index[meta]
+	if !ok {
+		byKind = map[RelationKind][]*DependencyRelation{}
+		index[meta] = byKind
+	}
+	byKind[d.kind] = append(byKind[d.kind], d)
+}
+
+// 以meta为起点的某种依赖关系
+func (this *analysisTool) outgoingRelations(meta *structMeta, kind RelationKind) []*DependencyRelation {
+	return this.symbols.outgoing[meta][kind]
+}
+
+func (this *analysisTool) findRelation(source *structMeta, target *structMeta, kind RelationKind) *DependencyRelation {
+	for _, d := range this.outgoingRelations(source, kind) {
+		if d.target == target {
+			return d
+		}
+	}
+	return nil
+}
+
+// 和meta相关的所有依赖关系, 按加入的先后排序
+func (this *analysisTool) relationsOf(meta *structMeta) []*DependencyRelation {
+
+	relations := []*DependencyRelation{}
+
+	for _, byKind := range []map[RelationKind][]*DependencyRelation{this.symbols.outgoing[meta], this.symbols.incoming[meta]} {
+		for _, ds := range byKind {
+			relations = append(relations, ds...)
+		}
+	}
+
+	sortRelations(relations)
+	return relations
+}
+
+func sortRelations(relations []*DependencyRelation) {
+	sort.Slice(relations, func(i, j int) bool {
+		return relations[i].index < relations[j].index
+	})
+}
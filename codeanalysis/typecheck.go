@@ -0,0 +1,379 @@
+package codeanalysis
+
+import (
+	"errors"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// 用go/types对扫描到的包做类型检查, 扫描目录内的包从源码导入, 其他包交给go/importer
+type typeChecker struct {
+	fset *token.FileSet
+	// 包路径与该包的go文件(不包括测试文件)
+	packageFiles map[string][]*ast.File
+	// 已经检查过的包
+	packages map[string]*types.Package
+	// 正在检查的包, 用于发现循环引用
+	checking map[string]bool
+	// 扫描目录以外的包
+	fallback types.ImporterFrom
+	info     *types.Info
+}
+
+func newTypeChecker(fset *token.FileSet, fallback types.ImporterFrom) *typeChecker {
+	return &typeChecker{
+		fset:         fset,
+		packageFiles: map[string][]*ast.File{},
+		packages:     map[string]*types.Package{},
+		checking:     map[string]bool{},
+		fallback:     fallback,
+		info: &types.Info{
+			Types: map[ast.Expr]types.TypeAndValue{},
+			Defs:  map[*ast.Ident]types.Object{},
+			Uses:  map[*ast.Ident]types.Object{},
+			// 函数体中方法调用的接收者类型
+			Selections: map[*ast.SelectorExpr]*types.Selection{},
+		},
+	}
+}
+
+func (this *typeChecker) Import(path string) (*types.Package, error) {
+	return this.ImportFrom(path, "", 0)
+}
+
+func (this *typeChecker) ImportFrom(path string, dir string, mode types.ImportMode) (*types.Package, error) {
+
+	if pkg, ok := this.packages[path]; ok {
+		return pkg, nil
+	}
+
+	files, ok := this.packageFiles[path]
+	if !ok {
+		return this.fallback.ImportFrom(path, dir, mode)
+	}
+
+	if this.checking[path] {
+		return nil, errors.New("import cycle: " + path)
+	}
+	this.checking[path] = true
+	defer delete(this.checking, path)
+
+	pkg := this.check(path, files)
+	this.packages[path] = pkg
+	return pkg, nil
+}
+
+func (this *typeChecker) check(path string, files []*ast.File) *types.Package {
+
+	errorCount := 0
+	conf := types.Config{
+		Importer:    this,
+		FakeImportC: true,
+		Error: func(err error) {
+			errorCount++
+			log.Debugf("类型检查%s, %s\n", path, err)
+		},
+	}
+
+	// 有错误的时候依然会返回部分结果, 无法确定的类型由AST方式兜底
+	pkg, _ := conf.Check(path, this.fset, files, this.info)
+	if errorCount > 0 {
+		log.Warnf("类型检查包%s有%d个错误\n", path, errorCount)
+	}
+
+	return pkg
+}
+
+// 对扫描目录中的所有包做类型检查
+func (this *analysisTool) typeCheck() {
+
+	// 外部包的导入结果和文件不变, 监视模式下重新解析时继续使用
+	if this.importer == nil {
+		this.importer = this.newSourceImporter()
+	}
+	checker := newTypeChecker(this.fset, this.importer)
+
+	// 同一目录下可以有多个包, 例如 // +build ignore 的package main, 按文件自己的包名分组检查
+	groups := map[string]map[string][]*ast.File{}
+	for _, file := range this.parsedFiles {
+
+		if file.isTest {
+			continue
+		}
+
+		packagePath := file.packagePath
+		if packagePath == "" {
+			continue
+		}
+
+		if groups[packagePath] == nil {
+			groups[packagePath] = map[string][]*ast.File{}
+		}
+		packageName := file.file.Name.Name
+		groups[packagePath][packageName] = append(groups[packagePath][packageName], file.file)
+	}
+
+	// 被import的是和记录的包名相同的一组, 其他的组单独检查
+	others := map[string][][]*ast.File{}
+	for packagePath, byName := range groups {
+
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		packageName, ok := this.packagePathPackageNameCache[packagePath]
+		if _, found := byName[packageName]; !ok || !found {
+			packageName = names[0]
+		}
+		checker.packageFiles[packagePath] = byName[packageName]
+
+		for _, name := range names {
+			if name != packageName {
+				others[packagePath] = append(others[packagePath], byName[name])
+			}
+		}
+	}
+
+	packagePaths := make([]string, 0, len(checker.packageFiles))
+	for packagePath := range checker.packageFiles {
+		packagePaths = append(packagePaths, packagePath)
+	}
+	sort.Strings(packagePaths)
+
+	for _, packagePath := range packagePaths {
+		log.Info("类型检查 " + packagePath)
+		checker.Import(packagePath)
+		for _, files := range others[packagePath] {
+			log.Infof("类型检查 %s (package %s)", packagePath, files[0].Name.Name)
+			checker.check(packagePath, files)
+		}
+	}
+
+	for _, meta := range this.structMetas {
+		pkg := checker.packages[meta.PackagePath]
+		if pkg == nil || meta.isTest {
+			continue
+		}
+
+		if typeName, ok := pkg.Scope().Lookup(meta.Name).(*types.TypeName); ok {
+			meta.typeName = typeName
+		}
+	}
+
+	this.typesInfo = checker.info
+}
+
+// 扫描目录以外的包, 用和解析相同的构建环境找到源码后自己做类型检查.
+// 使用单独的FileSet, 外部包的文件不会加到解析用的FileSet中
+type sourceImporter struct {
+	ctxt build.Context
+	fset *token.FileSet
+	// 按包所在目录缓存, vendor目录中的包和GOPATH中的同名包是不同的包, 正在导入的为nil
+	packages map[string]*types.Package
+}
+
+// GOPATH和vendor目录按Config确定: 代码目录不在go模块中时按GOPATH方式查找, 否则交给go命令
+func (this *analysisTool) newSourceImporter() *sourceImporter {
+
+	ctxt := this.buildContext
+	// 只需要类型信息, 和CGO_ENABLED=0一样选择纯go的文件, 避免处理cgo
+	ctxt.CgoEnabled = false
+	if this.config.GopathDir != "" {
+		ctxt.GOPATH = this.config.GopathDir
+
+		if this.findModule(this.config.CodeDir) == nil {
+			// go/build只在文件系统回调都为空时才调用go命令按模块查找, 设置回调后按GOPATH和vendor目录查找
+			ctxt.IsDir = func(dir string) bool {
+				info, err := os.Stat(dir)
+				return err == nil && info.IsDir()
+			}
+		}
+	}
+
+	return &sourceImporter{
+		ctxt:     ctxt,
+		fset:     token.NewFileSet(),
+		packages: map[string]*types.Package{},
+	}
+}
+
+func (this *sourceImporter) Import(path string) (*types.Package, error) {
+	return this.ImportFrom(path, "", 0)
+}
+
+// dir是import所在文件的目录, 用来查找vendor目录
+func (this *sourceImporter) ImportFrom(path string, dir string, mode types.ImportMode) (*types.Package, error) {
+
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+
+	bp, err := this.ctxt.Import(path, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg, ok := this.packages[bp.Dir]; ok {
+		if pkg == nil {
+			return nil, errors.New("import cycle: " + path)
+		}
+		return pkg, nil
+	}
+	this.packages[bp.Dir] = nil
+
+	files := make([]*ast.File, 0, len(bp.GoFiles))
+	for _, name := range bp.GoFiles {
+		file, err := parser.ParseFile(this.fset, filepath.Join(bp.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			log.Debugf("解析外部包%s的文件%s失败, %s\n", bp.ImportPath, name, err)
+		}
+		if file != nil {
+			files = append(files, file)
+		}
+	}
+
+	errorCount := 0
+	conf := types.Config{
+		Importer:         this,
+		FakeImportC:      true,
+		IgnoreFuncBodies: true,
+		Error: func(err error) {
+			errorCount++
+		},
+	}
+
+	// 和扫描的包一样, 有错误时也使用部分结果
+	pkg, _ := conf.Check(bp.ImportPath, this.fset, files, nil)
+	if errorCount > 0 {
+		log.Debugf("类型检查外部包%s有%d个错误\n", bp.ImportPath, errorCount)
+	}
+
+	this.packages[bp.Dir] = pkg
+	return pkg, nil
+}
+
+// 通过类型检查的结果查找标识符对应的类型, ok为false表示没有类型信息, 需要走AST方式
+func (this *analysisTool) lookupTypeName(t ast.Expr) (typeName *types.TypeName, ok bool) {
+
+	if this.typesInfo == nil {
+		return nil, false
+	}
+
+	var ident *ast.Ident
+
+	switch expr := t.(type) {
+	case *ast.Ident:
+		ident = expr
+	case *ast.SelectorExpr:
+		ident = expr.Sel
+	default:
+		return nil, false
+	}
+
+	obj, found := this.typesInfo.Uses[ident]
+	if !found {
+		return nil, false
+	}
+
+	typeName, ok = obj.(*types.TypeName)
+	return typeName, ok
+}
+
+// 通过类型检查的结果查找struct/interface
+func (this *analysisTool) findStructByTypes(t ast.Expr) (*structMeta, bool) {
+
+	typeName, ok := this.lookupTypeName(t)
+	if !ok {
+		return nil, false
+	}
+
+	if typeName.Pkg() == nil {
+		// error等内置类型
+		return nil, true
+	}
+
+	// 类型参数, 和AST方式一样不依赖同名的类型
+	if _, ok := typeName.Type().(*types.TypeParam); ok {
+		return nil, true
+	}
+
+	return this.findStruct(typeName.Pkg().Path(), typeName.Name()), true
+}
+
+// 通过类型检查的结果得到带包路径的类型名, 和addPackagePathWhenStruct的格式一致
+func (this *analysisTool) uniqueTypeNameByTypes(t ast.Expr) (string, bool) {
+
+	typeName, ok := this.lookupTypeName(t)
+	if !ok {
+		return "", false
+	}
+
+	// 别名换成它指向的类型, 和AST方式一样
+	if typeName.IsAlias() {
+		target := types.Unalias(typeName.Type())
+		if named, ok := target.(*types.Named); ok && named.TypeArgs().Len() == 0 {
+			typeName = named.Obj()
+		} else {
+			return types.TypeString(target, func(pkg *types.Package) string {
+				return pkg.Path()
+			}), true
+		}
+	}
+
+	if typeName.Pkg() == nil {
+		return typeName.Name(), true
+	}
+
+	// 类型参数, 和AST方式一样不加包路径
+	if _, ok := typeName.Type().(*types.TypeParam); ok {
+		return typeName.Name(), true
+	}
+
+	return typeName.Pkg().Path() + "." + typeName.Name(), true
+}
+
+// 通过类型检查的结果判断impl或者*impl是否实现了接口, ok为false表示没有类型信息
+func (this *analysisTool) implementsByTypes(definedInterface, impl *structMeta) (implements bool, pointerOnly bool, ok bool) {
+
+	if definedInterface.typeName == nil || impl.typeName == nil {
+		return false, false, false
+	}
+
+	// 没有实例化的泛型类型无法用types.Implements判断, 交给方法签名比较
+	if isGenericType(definedInterface.typeName) || isGenericType(impl.typeName) {
+		return false, false, false
+	}
+
+	iface, isInterface := definedInterface.typeName.Type().Underlying().(*types.Interface)
+	if !isInterface {
+		return false, false, false
+	}
+
+	// 空接口和只能用作类型约束的接口不画实现关系
+	if iface.NumMethods() == 0 || !iface.IsMethodSet() {
+		return false, false, true
+	}
+
+	t := impl.typeName.Type()
+	if types.Implements(t, iface) {
+		return true, false, true
+	}
+
+	pointer := types.Implements(types.NewPointer(t), iface)
+	return pointer, pointer, true
+}
+
+func isGenericType(typeName *types.TypeName) bool {
+	named, ok := typeName.Type().(*types.Named)
+	return ok && named.TypeParams().Len() > 0
+}
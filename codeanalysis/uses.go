@@ -0,0 +1,57 @@
+package codeanalysis
+
+import (
+	"go/ast"
+)
+
+// 方法的参数和返回值用到的struct/interface, 作为虚线的"uses"依赖, 每对类型只画一次
+func (this *fileContext) visitMethodUses(meta *structMeta, funcType *ast.FuncType) {
+
+	if !this.config.ShowUses {
+		return
+	}
+
+	fields := []*ast.Field{}
+	if funcType.Params != nil {
+		fields = append(fields, funcType.Params.List...)
+	}
+	if funcType.Results != nil {
+		fields = append(fields, funcType.Results.List...)
+	}
+
+	for _, field := range fields {
+
+		usedMeta1 := this.paramTypeMeta(field.Type)
+		if usedMeta1 == nil || usedMeta1 == meta || this.hasNewRelation(meta, usedMeta1, UsesRelation) {
+			continue
+		}
+
+		this.newRelations = append(this.newRelations, &DependencyRelation{
+			source: meta,
+			target: usedMeta1,
+			uml:    meta.UniqueNameUML() + " ..> " + usedMeta1.UniqueNameUML() + " : uses",
+			kind:   UsesRelation,
+		})
+	}
+}
+
+// 参数或返回值类型对应的struct/interface, 可变参数 ...T 对应T
+func (this *fileContext) paramTypeMeta(t ast.Expr) *structMeta {
+
+	ellipsis, ok := t.(*ast.Ellipsis)
+	if ok {
+		t = ellipsis.Elt
+	}
+
+	meta, _ := this.analysisTypeForDependencyRelation(t)
+	return meta
+}
+
+func (this *fileContext) hasNewRelation(source *structMeta, target *structMeta, kind RelationKind) bool {
+	for _, d := range this.newRelations {
+		if d.source == source && d.target == target && d.kind == kind {
+			return true
+		}
+	}
+	return false
+}
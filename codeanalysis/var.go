@@ -0,0 +1,213 @@
+package codeanalysis
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// 包级别的变量, 变量的类型可能要等构造函数都合并后才能确定
+type pendingVar struct {
+	baseInfo
+	name   string
+	isTest bool
+	// 有初始值的是<<singleton>>, 只声明的是<<global>>
+	initialized bool
+	target      *structMeta
+	// 用构造函数初始化时, 构造函数的包路径和函数名
+	constructor *typeKey
+}
+
+// 类型是已扫描的struct/interface的包级别变量, 例如 var defaultRegistry = &Registry{}
+func (this *fileContext) visitVarDecl(genDecl *ast.GenDecl) {
+
+	for _, spec := range genDecl.Specs {
+
+		valueSpec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+
+		for i, name := range valueSpec.Names {
+
+			if name.Name == "_" {
+				this.visitAssertion(valueSpec, i)
+				continue
+			}
+
+			if this.inIgnoreNode(name.Name) {
+				continue
+			}
+
+			pending := &pendingVar{
+				baseInfo: baseInfo{
+					FilePath:    this.currentFile,
+					PackagePath: this.currentPackagePath,
+				},
+				name:        name.Name,
+				isTest:      this.file.isTest,
+				initialized: len(valueSpec.Values) > 0,
+			}
+
+			if meta, ok := this.varTypeByTypes(name); ok {
+				pending.target = meta
+			} else if valueSpec.Type != nil {
+				pending.target, _ = this.analysisTypeForDependencyRelation(valueSpec.Type)
+			} else if len(valueSpec.Values) == len(valueSpec.Names) {
+				pending.target, pending.constructor = this.valueTypeMeta(valueSpec.Values[i])
+			}
+
+			if pending.target != nil || pending.constructor != nil {
+				this.newVars = append(this.newVars, pending)
+			}
+		}
+	}
+}
+
+// 通过类型检查的结果确定变量的类型, ok为false表示没有类型信息
+func (this *fileContext) varTypeByTypes(name *ast.Ident) (*structMeta, bool) {
+
+	if this.typesInfo == nil {
+		return nil, false
+	}
+
+	v, ok := this.typesInfo.Defs[name].(*types.Var)
+	if !ok {
+		return nil, false
+	}
+
+	t := v.Type()
+	pointer, ok := t.(*types.Pointer)
+	if ok {
+		t = pointer.Elem()
+	}
+
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil, true
+	}
+
+	return this.findStruct(named.Obj().Pkg().Path(), named.Obj().Name()), true
+}
+
+// 初始值的类型: &Registry{}, new(Registry), Registry(x), 或者构造函数 NewRegistry()
+func (this *fileContext) valueTypeMeta(value ast.Expr) (*structMeta, *typeKey) {
+
+	unaryExpr, ok := value.(*ast.UnaryExpr)
+	if ok && unaryExpr.Op == token.AND {
+		return this.valueTypeMeta(unaryExpr.X)
+	}
+
+	compositeLit, ok := value.(*ast.CompositeLit)
+	if ok && compositeLit.Type != nil {
+		return this.bodyTypeMeta(compositeLit.Type), nil
+	}
+
+	callExpr, ok := value.(*ast.CallExpr)
+	if !ok {
+		return nil, nil
+	}
+
+	ident, ok := callExpr.Fun.(*ast.Ident)
+	if ok && ident.Name == "new" && len(callExpr.Args) == 1 {
+		return this.bodyTypeMeta(callExpr.Args[0]), nil
+	}
+
+	if meta := this.bodyTypeMeta(callExpr.Fun); meta != nil {
+		return meta, nil
+	}
+
+	fun, _ := splitTypeArguments(callExpr.Fun)
+
+	ident, ok = fun.(*ast.Ident)
+	if ok {
+		return nil, &typeKey{PackagePath: this.currentPackagePath, Name: ident.Name}
+	}
+
+	selectorExpr, ok := fun.(*ast.SelectorExpr)
+	if ok {
+		x, ok := selectorExpr.X.(*ast.Ident)
+		if !ok {
+			return nil, nil
+		}
+		for _, import1 := range this.currentFileImports {
+			if import1.Alias == x.Name {
+				return nil, &typeKey{PackagePath: import1.Path, Name: selectorExpr.Sel.Name}
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// 初始值在缓存中只保存valueTypeMeta用到的部分, 例如 &Registry{...} 保存为 &Registry{}
+func varValueSnippet(value ast.Expr, snippet func(node ast.Node) string) string {
+
+	unaryExpr, ok := value.(*ast.UnaryExpr)
+	if ok && unaryExpr.Op == token.AND {
+		return "&" + varValueSnippet(unaryExpr.X, snippet)
+	}
+
+	compositeLit, ok := value.(*ast.CompositeLit)
+	if ok && compositeLit.Type != nil {
+		return snippet(compositeLit.Type) + "{}"
+	}
+
+	callExpr, ok := value.(*ast.CallExpr)
+	if ok {
+		ident, ok := callExpr.Fun.(*ast.Ident)
+		if ok && ident.Name == "new" && len(callExpr.Args) == 1 {
+			return "new(" + snippet(callExpr.Args[0]) + ")"
+		}
+		if isTypeLikeExpr(callExpr.Fun) {
+			return snippet(callExpr.Fun) + "()"
+		}
+	}
+
+	return "nil"
+}
+
+// 所有文件合并后, 确定用构造函数初始化的变量的类型, 变量显示为对象节点
+func (this *analysisTool) resolveVars() {
+
+	for _, v := range this.vars {
+
+		target := v.target
+		if target == nil {
+			target = this.symbols.constructors[*v.constructor]
+		}
+		// 只显示代码目录中的struct/interface类型的变量, 外部类型和func/slice/map等定义的类型不显示
+		if target == nil || (target.category != StructCategory && target.category != InterfaceCategory) {
+			continue
+		}
+
+		varMeta1 := &structMeta{
+			baseInfo:    v.baseInfo,
+			Name:        v.name,
+			MethodSigns: []string{},
+			category:    VarCategory,
+			isTest:      v.isTest,
+		}
+		varMeta1.UML = this.varToUML(varMeta1, v.initialized)
+
+		this.addDisplayNode(varMeta1)
+		this.addDependencyRelation(&DependencyRelation{
+			source: varMeta1,
+			target: target,
+			uml:    varMeta1.UniqueNameUML() + " ..> " + target.UniqueNameUML() + " : instance of",
+			kind:   VarRelation,
+		})
+	}
+
+	this.vars = nil
+}
+
+func (this *analysisTool) varToUML(me *structMeta, initialized bool) string {
+	stereotype := " <<global>>"
+	if initialized {
+		stereotype = " <<singleton>>"
+	}
+	objectUML := "object " + me.Name + stereotype + me.ColorfulUML()
+	return fmt.Sprintf("namespace %s {\n %s \n}", this.packagePathToUML(me.PackagePath), objectUML)
+}
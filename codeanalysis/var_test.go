@@ -0,0 +1,73 @@
+package codeanalysis
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestModule(t *testing.T, files map[string]string) string {
+
+	dir, err := ioutil.TempDir("", "codeanalysis")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files["go.mod"] = "module example.com/app\n\ngo 1.20\n"
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func varNodes(tool *analysisTool) []string {
+	names := []string{}
+	for _, meta := range tool.structMetas {
+		if meta.category == VarCategory {
+			names = append(names, meta.Name)
+		}
+	}
+	return names
+}
+
+func TestVarsOfExternalAndDefinedTypesAreNotShown(t *testing.T) {
+
+	dir := writeTestModule(t, map[string]string{
+		"app.go": `package app
+
+import "sync"
+
+type Registry struct{}
+
+type Handler func()
+
+var mu sync.Mutex
+
+var handler Handler
+
+var defaultRegistry = &Registry{}
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	tool := analysisCode(Config{CodeDir: dir, ShowExternal: true}, nil)
+
+	names := varNodes(tool)
+	if len(names) != 1 || names[0] != "defaultRegistry" {
+		t.Fatalf("var nodes = %v, want [defaultRegistry]", names)
+	}
+
+	for _, meta := range tool.structMetas {
+		if meta.category == ExternalCategory {
+			t.Errorf("unexpected external node %s.%s", meta.PackagePath, meta.Name)
+		}
+	}
+}
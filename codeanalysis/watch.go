@@ -0,0 +1,125 @@
+package codeanalysis
+
+import (
+	"go/token"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// go文件的修改时间和大小, 用来判断文件是否变化
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newFileStamp(info os.FileInfo) fileStamp {
+	return fileStamp{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+func (this fileStamp) equal(other fileStamp) bool {
+	return this.modTime.Equal(other.modTime) && this.size == other.size
+}
+
+// 监视代码目录, 每隔interval检查一次go文件, 有文件新增, 修改或者删除时重新解析.
+// 每次解析完成后调用handle, 不会返回.
+// 只重新读取和解析有文件变化的目录, 也就是受影响的包, 其他文件使用上次解析的语法树;
+// 类型和依赖关系跨越多个包, 仍然基于所有文件的语法树重新计算
+func WatchCode(config Config, interval time.Duration, handle func(result AnalysisResult)) {
+
+	facts := newFactCache(config.CacheDir)
+
+	var previous *analysisTool
+	var dirs []string
+
+	for {
+		tool := newAnalysisTool(facts)
+		if previous != nil {
+			tool.reuseFiles(previous, dirs)
+		}
+		tool.analysis(config)
+		handle(tool)
+		previous = tool
+
+		for {
+			time.Sleep(interval)
+
+			changedFiles := tool.changedFiles()
+			if len(changedFiles) > 0 {
+				dirs = changedDirs(changedFiles)
+				log.Infof("%d个文件有变化, 涉及目录%v, 重新解析\n", len(changedFiles), dirs)
+				break
+			}
+		}
+	}
+}
+
+// 使用上次解析的结果, dirs以外的文件不再重新读取和解析.
+// 语法树的位置信息在上次的FileSet中, 所以共用FileSet; 需要重新解析的文件, 包括解析失败和已经删除的文件,
+// 加到FileSet中的所有token.File都删除, 缓存重建语法树时每个表达式也是单独的token.File, 文件名都是go文件路径.
+// 外部包的导入结果也继续使用, 不会每次都重新导入标准库
+func (this *analysisTool) reuseFiles(previous *analysisTool, dirs []string) {
+
+	this.fset = previous.fset
+	this.importer = previous.importer
+	this.reusableFiles = map[string]*parsedFile{}
+
+	for filepath, file := range previous.files {
+		if !sliceContains(dirs, path.Dir(filepath)) {
+			this.reusableFiles[filepath] = file
+		}
+	}
+
+	removed := []*token.File{}
+	this.fset.Iterate(func(tokenFile *token.File) bool {
+		if _, ok := this.reusableFiles[tokenFile.Name()]; !ok {
+			removed = append(removed, tokenFile)
+		}
+		return true
+	})
+	for _, tokenFile := range removed {
+		this.fset.RemoveFile(tokenFile)
+	}
+}
+
+// 和解析时相比, 新增, 修改或者删除了的go文件
+func (this *analysisTool) changedFiles() []string {
+
+	changed := []string{}
+	seen := map[string]bool{}
+
+	this.walkAllGoFiles(func(path string, info os.FileInfo) {
+		seen[path] = true
+		if stamp, ok := this.fileStamps[path]; !ok || !stamp.equal(newFileStamp(info)) {
+			changed = append(changed, path)
+		}
+	})
+
+	for path := range this.fileStamps {
+		if !seen[path] {
+			changed = append(changed, path)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+func changedDirs(files []string) []string {
+
+	dirs := []string{}
+	for _, file := range files {
+		dir := path.Dir(file)
+		if !sliceContains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"regexp"
 
@@ -17,8 +18,9 @@ func main() {
 	log.SetLevel(log.InfoLevel)
 
 	var opts struct {
-		CodeDir         string   `long:"codedir" description:"要扫描的代码目录" required:"true"`
-		GopathDir       string   `long:"gopath" description:"GOPATH目录" required:"true"`
+		CodeDir         string   `long:"codedir" description:"要扫描的代码目录"`
+		WorkspaceDir    string   `long:"workspace" description:"go.work所在目录,扫描工作区中的所有模块"`
+		GopathDir       string   `long:"gopath" description:"GOPATH目录,代码目录不在go模块中时才需要"`
 		OutputDir       string   `long:"outputdir" description:"解析结果保存到该文件夹" required:"true"`
 		IgnoreDirs      []string `long:"ignoredir" description:"需要排除的目录,不需要扫描和解析"`
 		TestPartialDirs []string `long:"testpartialdir" description:"测试部分目录，比如mocks，test，系统自己增加 /开头，/结尾"`
@@ -26,11 +28,30 @@ func main() {
 		NodeName        string   `long:"nodename" description:"struct/interface名字"`
 		NodeDepth       uint16   `long:"nodedepth" description:"struct/interface关系度"`
 		ShowTest        string   `long:"showtest" description:"是否显示 测试类yes/no"`
+		TypeCheck       bool     `long:"typecheck" description:"用go/types做类型检查来确定类型,检查失败的地方仍然按import别名推断"`
+		Jobs            int      `long:"jobs" description:"并发解析文件的goroutine数量,默认为CPU个数"`
+		CacheDir        string   `long:"cachedir" description:"缓存目录,只重新解析内容变化了的文件"`
+		GOOS            string   `long:"goos" description:"构建环境的GOOS,默认为本机"`
+		GOARCH          string   `long:"goarch" description:"构建环境的GOARCH,默认为本机"`
+		BuildTags       []string `long:"tags" description:"构建标签,可以多次指定"`
+		AnnotateTags    bool     `long:"annotatetags" description:"标出只在特定构建约束下存在的struct/interface"`
+		ShowAlias       bool     `long:"showalias" description:"把type A = B形式的别名显示为单独的节点,指向它的目标类型"`
+		ShowUses        bool     `long:"showuses" description:"显示方法的参数和返回值类型形成的依赖"`
+		FollowBody      bool     `long:"followbody" description:"按nodename过滤时,也沿着函数体中创建和调用的类型查找"`
+		CheckAssertions bool     `long:"checkassertions" description:"报告没有var _ I = (*T)(nil)断言的实现关系"`
+		ShowExternal    bool     `long:"showexternal" description:"把代码目录以外的类型(例如sync.Mutex)显示为灰色的节点"`
+		ReportFile      string   `long:"report" description:"解析过程中发现的问题保存到该文件"`
+		ReportFormat    string   `long:"reportformat" description:"问题报告的格式text/json" default:"text"`
+		Strict          bool     `long:"strict" description:"解析过程中发现问题时以非0退出"`
+		Watch           bool     `long:"watch" description:"监视代码目录,go文件变化时重新生成"`
+		WatchInterval   uint     `long:"watchinterval" description:"监视模式下检查文件变化的间隔秒数" default:"2"`
 	}
 
 	if len(os.Args) == 1 {
 		fmt.Println("使用例子\n" +
-			os.Args[0] + " --codedir /appdev/gopath/src/github.com/contiv/netplugin --gopath /appdev/gopath --outputfile  /tmp/result")
+			os.Args[0] + " --codedir /appdev/gopath/src/github.com/contiv/netplugin --gopath /appdev/gopath --outputdir /tmp/result\n" +
+			os.Args[0] + " --codedir /appdev/netplugin --outputdir /tmp/result\n" +
+			os.Args[0] + " --workspace /appdev/monorepo --outputdir /tmp/result")
 		os.Exit(1)
 	}
 
@@ -41,16 +62,15 @@ func main() {
 	}
 
 	if opts.CodeDir == "" {
-		panic("代码目录不能为空")
-		os.Exit(1)
+		opts.CodeDir = opts.WorkspaceDir
 	}
 
-	if opts.GopathDir == "" {
-		panic("GOPATH目录不能为空")
+	if opts.CodeDir == "" {
+		panic("代码目录不能为空")
 		os.Exit(1)
 	}
 
-	if !strings.HasPrefix(opts.CodeDir, opts.GopathDir) {
+	if opts.GopathDir != "" && !strings.HasPrefix(opts.CodeDir, opts.GopathDir) {
 		panic(fmt.Sprintf("代码目录%s,必须是GOPATH目录%s的子目录", opts.CodeDir, opts.GopathDir))
 		os.Exit(1)
 	}
@@ -62,6 +82,11 @@ func main() {
 		}
 	}
 
+	// 间隔为0时会不停地遍历代码目录
+	if opts.Watch && opts.WatchInterval == 0 {
+		panic("监视间隔必须大于0秒")
+	}
+
 	config := codeanalysis.Config{
 		CodeDir:         opts.CodeDir,
 		GopathDir:       opts.GopathDir,
@@ -69,11 +94,55 @@ func main() {
 		IgnoreDirs:      dealPath(opts.IgnoreDirs),
 		TestPartialDirs: dealTestPartialDirs(opts.TestPartialDirs),
 		IgnoreNodes:     opts.IgnoreNodes,
+		WorkspaceDir:    opts.WorkspaceDir,
+		TypeCheck:       opts.TypeCheck,
+		Jobs:            opts.Jobs,
+		CacheDir:        opts.CacheDir,
+		GOOS:            opts.GOOS,
+		GOARCH:          opts.GOARCH,
+		BuildTags:       dealBuildTags(opts.BuildTags),
+		AnnotateTags:    opts.AnnotateTags,
+		ShowAlias:       opts.ShowAlias,
+		ShowUses:        opts.ShowUses,
+		FollowBody:      opts.FollowBody,
+		CheckAssertions: opts.CheckAssertions,
+		ShowExternal:    opts.ShowExternal,
+	}
+
+	output := func(result codeanalysis.AnalysisResult) error {
+		if err := result.OutputToFile(opts.OutputDir, opts.NodeName, opts.NodeDepth, opts.ShowTest == "true"); err != nil {
+			log.Errorf("保存UML失败, %s", err)
+			return err
+		}
+
+		if opts.ReportFile != "" {
+			if err := result.WriteDiagnostics(opts.ReportFile, opts.ReportFormat); err != nil {
+				log.Errorf("保存问题报告失败, %s", err)
+				return err
+			}
+		}
+		return nil
+	}
+
+	if opts.Watch {
+		codeanalysis.WatchCode(config, time.Duration(opts.WatchInterval)*time.Second, func(result codeanalysis.AnalysisResult) {
+			// 监视模式下出错不退出, 等文件再次变化时重新生成
+			if err := output(result); err != nil {
+				log.Errorf("重新生成失败, 继续监视, %s", err)
+			}
+		})
 	}
 
 	result := codeanalysis.AnalysisCode(config)
 
-	result.OutputToFile(opts.OutputDir, opts.NodeName, opts.NodeDepth, opts.ShowTest == "true")
+	if err := output(result); err != nil {
+		os.Exit(1)
+	}
+
+	if opts.Strict && len(result.Diagnostics()) > 0 {
+		log.Errorf("解析过程中发现%d个问题", len(result.Diagnostics()))
+		os.Exit(2)
+	}
 
 }
 func dealTestPartialDirs(testPartialDirs []string) (result []string) {
@@ -89,6 +158,18 @@ func dealTestPartialDirs(testPartialDirs []string) (result []string) {
 	return
 }
 
+// --tags 支持 a,b 的写法
+func dealBuildTags(buildTags []string) (result []string) {
+	for _, s := range buildTags {
+		for _, tag := range strings.Split(s, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				result = append(result, tag)
+			}
+		}
+	}
+	return
+}
+
 func dealPath(ignoreDirs []string) []string {
 	var re = regexp.MustCompile(`(/){2,}`)
 	arr := make([]string, 0, len(ignoreDirs))